@@ -0,0 +1,565 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// The builders below create resources and
+// constraints with ids derived from the id of
+// the object they belong to, following the
+// conventions of pcs, e.g.
+// "myAddr-instance_attributes-ip" or
+// "myAddr-monitor-interval-10s". ToXML returns
+// the element ready to pass to Cib.Create with
+// the "resources" or "constraints" section.
+
+// Implemented by the builders of all kinds of
+// resources.
+type ResourceBuilder interface {
+	Resource() *Resource
+	ToXML() (string, error)
+}
+
+// Builds a primitive resource.
+type PrimitiveBuilder struct {
+	rsc *Resource
+}
+
+// Starts a primitive using the given agent, as
+// "class:provider:type" for OCF agents or
+// "class:type", e.g. "ocf:heartbeat:IPaddr" or
+// "systemd:httpd".
+func NewPrimitive(id, agent string) *PrimitiveBuilder {
+	rsc := newResource(PrimitiveResource, id)
+	parts := strings.SplitN(agent, ":", 3)
+	switch len(parts) {
+	case 3:
+		rsc.Class, rsc.Provider, rsc.Type = parts[0], parts[1], parts[2]
+	case 2:
+		rsc.Class, rsc.Type = parts[0], parts[1]
+	default:
+		rsc.Type = agent
+	}
+	return &PrimitiveBuilder{rsc}
+}
+
+// Sets an instance attribute, passed to the
+// agent as a parameter.
+func (b *PrimitiveBuilder) Param(name, value string) *PrimitiveBuilder {
+	b.rsc.InstanceAttributes = setNvpair(b.rsc.InstanceAttributes, b.rsc.Id+"-instance_attributes", name, value)
+	return b
+}
+
+func (b *PrimitiveBuilder) Meta(name, value string) *PrimitiveBuilder {
+	b.rsc.MetaAttributes = setNvpair(b.rsc.MetaAttributes, b.rsc.Id+"-meta_attributes", name, value)
+	return b
+}
+
+func (b *PrimitiveBuilder) Utilization(name, value string) *PrimitiveBuilder {
+	b.rsc.Utilization = setNvpair(b.rsc.Utilization, b.rsc.Id+"-utilization", name, value)
+	return b
+}
+
+// Adds an operation, e.g. Op("monitor", "10s").
+// Adding an operation with the same name and
+// interval again replaces it.
+func (b *PrimitiveBuilder) Op(name, interval string, options ...func(*Operation)) *PrimitiveBuilder {
+	op := &Operation{
+		Id:       sanitizeId(b.rsc.Id + "-" + name + "-interval-" + interval),
+		Name:     name,
+		Interval: interval,
+	}
+	for _, opt := range options {
+		opt(op)
+	}
+	for i, existing := range b.rsc.Operations {
+		if existing.Id == op.Id {
+			b.rsc.Operations[i] = op
+			return b
+		}
+	}
+	b.rsc.Operations = append(b.rsc.Operations, op)
+	return b
+}
+
+// Option for Op setting the timeout.
+func OpWithTimeout(timeout string) func(*Operation) {
+	return func(op *Operation) {
+		op.Timeout = timeout
+	}
+}
+
+// Option for Op restricting the operation to
+// instances in the given role.
+func OpWithRole(role string) func(*Operation) {
+	return func(op *Operation) {
+		op.Role = role
+	}
+}
+
+// Option for Op setting the action to take
+// when the operation fails.
+func OpWithOnFail(action string) func(*Operation) {
+	return func(op *Operation) {
+		op.OnFail = action
+	}
+}
+
+func (b *PrimitiveBuilder) Resource() *Resource {
+	return b.rsc
+}
+
+func (b *PrimitiveBuilder) ToXML() (string, error) {
+	return marshalBuilt(b.rsc)
+}
+
+// Builds a group of primitives, started in
+// order on the same node.
+type GroupBuilder struct {
+	rsc *Resource
+}
+
+func NewGroup(id string, members ...*PrimitiveBuilder) *GroupBuilder {
+	b := &GroupBuilder{newResource(GroupResource, id)}
+	for _, m := range members {
+		b.Add(m)
+	}
+	return b
+}
+
+func (b *GroupBuilder) Add(member *PrimitiveBuilder) *GroupBuilder {
+	b.rsc.Children = append(b.rsc.Children, member.Resource())
+	return b
+}
+
+func (b *GroupBuilder) Meta(name, value string) *GroupBuilder {
+	b.rsc.MetaAttributes = setNvpair(b.rsc.MetaAttributes, b.rsc.Id+"-meta_attributes", name, value)
+	return b
+}
+
+func (b *GroupBuilder) Resource() *Resource {
+	return b.rsc
+}
+
+func (b *GroupBuilder) ToXML() (string, error) {
+	return marshalBuilt(b.rsc)
+}
+
+// Builds a clone of a primitive or group.
+type CloneBuilder struct {
+	rsc *Resource
+}
+
+// Clones the resource, with the id of the
+// resource followed by "-clone". Only
+// primitives and groups can be cloned; ToXML
+// returns an error for other resources.
+func NewClone(child ResourceBuilder) *CloneBuilder {
+	rsc := newResource(CloneResource, child.Resource().Id+"-clone")
+	rsc.Children = []*Resource{child.Resource()}
+	return &CloneBuilder{rsc}
+}
+
+// Clones the resource with the promotable meta
+// attribute set, so instances can be promoted.
+func NewPromotableClone(child ResourceBuilder) *CloneBuilder {
+	return NewClone(child).Meta("promotable", "true")
+}
+
+func (b *CloneBuilder) Meta(name, value string) *CloneBuilder {
+	b.rsc.MetaAttributes = setNvpair(b.rsc.MetaAttributes, b.rsc.Id+"-meta_attributes", name, value)
+	return b
+}
+
+func (b *CloneBuilder) Resource() *Resource {
+	return b.rsc
+}
+
+func (b *CloneBuilder) ToXML() (string, error) {
+	for _, child := range b.rsc.Children {
+		if kind := child.Kind(); kind != PrimitiveResource && kind != GroupResource {
+			return "", &CibError{msg: "Cannot clone " + string(kind) + " " + child.Id}
+		}
+	}
+	return marshalBuilt(b.rsc)
+}
+
+// Builds a bundle: a container image, and
+// optionally a primitive run inside it.
+type BundleBuilder struct {
+	rsc *Resource
+}
+
+func NewBundle(id string) *BundleBuilder {
+	return &BundleBuilder{newResource(BundleResource, id)}
+}
+
+// Runs the image with podman.
+func (b *BundleBuilder) Podman(image string) *BundleBuilder {
+	b.rsc.Docker = nil
+	b.rsc.Podman = &BundleContainer{Image: image}
+	return b
+}
+
+// Runs the image with docker.
+func (b *BundleBuilder) Docker(image string) *BundleBuilder {
+	b.rsc.Podman = nil
+	b.rsc.Docker = &BundleContainer{Image: image}
+	return b
+}
+
+// Sets the number of container instances. Must
+// be called after Podman or Docker.
+func (b *BundleBuilder) Replicas(n int) *BundleBuilder {
+	if c := b.container(); c != nil {
+		c.Replicas = fmt.Sprint(n)
+	}
+	return b
+}
+
+// Assigns each replica an address starting
+// from ipRangeStart, on the given host
+// interface if not empty.
+func (b *BundleBuilder) Network(ipRangeStart, hostInterface string) *BundleBuilder {
+	if b.rsc.Network == nil {
+		b.rsc.Network = &BundleNetwork{}
+	}
+	b.rsc.Network.IpRangeStart = ipRangeStart
+	b.rsc.Network.HostInterface = hostInterface
+	return b
+}
+
+// Forwards a port to the containers.
+func (b *BundleBuilder) Port(port string) *BundleBuilder {
+	if b.rsc.Network == nil {
+		b.rsc.Network = &BundleNetwork{}
+	}
+	b.rsc.Network.PortMappings = append(b.rsc.Network.PortMappings, &BundlePortMapping{
+		Id:   sanitizeId(b.rsc.Id + "-port-map-" + port),
+		Port: port,
+	})
+	return b
+}
+
+// Mounts a directory of the host in the
+// containers.
+func (b *BundleBuilder) Storage(sourceDir, targetDir string) *BundleBuilder {
+	id := b.rsc.Id + "-storage-map"
+	if n := len(b.rsc.Storage); n > 0 {
+		id = fmt.Sprintf("%s-%d", id, n)
+	}
+	b.rsc.Storage = append(b.rsc.Storage, &BundleStorageMount{
+		Id:        sanitizeId(id),
+		SourceDir: sourceDir,
+		TargetDir: targetDir,
+	})
+	return b
+}
+
+// Sets the primitive run in the containers,
+// replacing any set before.
+func (b *BundleBuilder) Primitive(p *PrimitiveBuilder) *BundleBuilder {
+	b.rsc.Children = []*Resource{p.Resource()}
+	return b
+}
+
+func (b *BundleBuilder) Meta(name, value string) *BundleBuilder {
+	b.rsc.MetaAttributes = setNvpair(b.rsc.MetaAttributes, b.rsc.Id+"-meta_attributes", name, value)
+	return b
+}
+
+func (b *BundleBuilder) container() *BundleContainer {
+	if b.rsc.Podman != nil {
+		return b.rsc.Podman
+	}
+	return b.rsc.Docker
+}
+
+func (b *BundleBuilder) Resource() *Resource {
+	return b.rsc
+}
+
+func (b *BundleBuilder) ToXML() (string, error) {
+	if b.container() == nil {
+		return "", &CibError{msg: "Bundle " + b.rsc.Id + " has no container"}
+	}
+	return marshalBuilt(b.rsc)
+}
+
+// Builds a location constraint.
+type LocationBuilder struct {
+	c     *LocationConstraint
+	exprs []*Expression
+}
+
+// Places the resource on the node with the
+// given score, e.g. ScoreInfinity to require
+// it or ScoreMinusInfinity to ban it.
+func NewLocation(rsc, node string, score Score) *LocationBuilder {
+	return &LocationBuilder{c: &LocationConstraint{
+		Id:    sanitizeId("location-" + rsc + "-" + node + "-" + score.String()),
+		Rsc:   rsc,
+		Node:  node,
+		Score: ScoreOf(score),
+	}}
+}
+
+// Places the resource with the given score on
+// the nodes matching all expressions added
+// with Expr. Rules with the same score for the
+// same resource need an id set with Id.
+func NewLocationRule(rsc string, score Score) *LocationBuilder {
+	b := &LocationBuilder{c: &LocationConstraint{
+		Id:  sanitizeId("location-" + rsc + "-" + score.String()),
+		Rsc: rsc,
+	}}
+	b.c.Rules = []*Rule{{Score: score}}
+	return b
+}
+
+// Adds an expression on a node attribute to
+// the rule, e.g. Expr("#uname", "eq", "node1").
+// Operations without a value, such as
+// "defined", take an empty value.
+func (b *LocationBuilder) Expr(attribute, operation, value string) *LocationBuilder {
+	b.exprs = append(b.exprs, &Expression{Attribute: attribute, Operation: operation, Value: value})
+	return b
+}
+
+// Overrides the generated id.
+func (b *LocationBuilder) Id(id string) *LocationBuilder {
+	b.c.Id = id
+	return b
+}
+
+// Applies the constraint only to instances in
+// the given role.
+func (b *LocationBuilder) Role(role string) *LocationBuilder {
+	b.c.Role = role
+	return b
+}
+
+// Sets resource-discovery, e.g. "never".
+func (b *LocationBuilder) Discovery(mode string) *LocationBuilder {
+	b.c.ResourceDiscovery = mode
+	return b
+}
+
+// Returns the constraint, with the ids of the
+// rule and its expressions derived from the id
+// of the constraint.
+func (b *LocationBuilder) Constraint() *LocationConstraint {
+	if len(b.c.Rules) > 0 {
+		rule := b.c.Rules[0]
+		rule.Id = b.c.Id + "-rule"
+		rule.Expressions = nil
+		for i, e := range b.exprs {
+			e.Id = rule.Id + "-expr"
+			if i > 0 {
+				e.Id = fmt.Sprintf("%s-%d", e.Id, i)
+			}
+			rule.Expressions = append(rule.Expressions, e)
+		}
+		if len(rule.Expressions) > 1 {
+			rule.BooleanOp = "and"
+		}
+	}
+	return b.c
+}
+
+func (b *LocationBuilder) ToXML() (string, error) {
+	c := b.Constraint()
+	if len(c.Rules) > 0 && len(c.Rules[0].Expressions) == 0 {
+		return "", &CibError{msg: "Location rule " + c.Id + " has no expressions"}
+	}
+	return marshalElement("rsc_location", c)
+}
+
+// Builds a colocation constraint.
+type ColocationBuilder struct {
+	c *ColocationConstraint
+}
+
+// Places rsc relative to withRsc with the given
+// score: positive to keep them together,
+// negative to keep them apart.
+func NewColocation(rsc, withRsc string, score Score) *ColocationBuilder {
+	return &ColocationBuilder{&ColocationConstraint{
+		Id:      sanitizeId("colocation-" + rsc + "-" + withRsc + "-" + score.String()),
+		Rsc:     rsc,
+		WithRsc: withRsc,
+		Score:   ScoreOf(score),
+	}}
+}
+
+// Overrides the generated id.
+func (b *ColocationBuilder) Id(id string) *ColocationBuilder {
+	b.c.Id = id
+	return b
+}
+
+// Restricts the constraint to the given roles
+// of the resources. An empty role matches all.
+func (b *ColocationBuilder) Roles(rscRole, withRscRole string) *ColocationBuilder {
+	b.c.RscRole = rscRole
+	b.c.WithRscRole = withRscRole
+	return b
+}
+
+func (b *ColocationBuilder) Constraint() *ColocationConstraint {
+	return b.c
+}
+
+func (b *ColocationBuilder) ToXML() (string, error) {
+	return marshalElement("rsc_colocation", b.c)
+}
+
+// Builds an ordering constraint.
+type OrderBuilder struct {
+	c *OrderConstraint
+}
+
+// Starts then after first has started.
+func NewOrder(first, then string) *OrderBuilder {
+	return &OrderBuilder{&OrderConstraint{
+		Id:    sanitizeId("order-" + first + "-" + then),
+		First: first,
+		Then:  then,
+	}}
+}
+
+// Overrides the generated id.
+func (b *OrderBuilder) Id(id string) *OrderBuilder {
+	b.c.Id = id
+	return b
+}
+
+// Orders the given actions instead of start,
+// e.g. Actions("promote", "start").
+func (b *OrderBuilder) Actions(firstAction, thenAction string) *OrderBuilder {
+	b.c.FirstAction = firstAction
+	b.c.ThenAction = thenAction
+	return b
+}
+
+// Sets the kind: "Mandatory", "Optional" or
+// "Serialize".
+func (b *OrderBuilder) Kind(kind string) *OrderBuilder {
+	b.c.Kind = kind
+	return b
+}
+
+// Sets whether the reverse order applies when
+// stopping.
+func (b *OrderBuilder) Symmetrical(symmetrical bool) *OrderBuilder {
+	b.c.Symmetrical = fmt.Sprint(symmetrical)
+	return b
+}
+
+func (b *OrderBuilder) Constraint() *OrderConstraint {
+	return b.c
+}
+
+func (b *OrderBuilder) ToXML() (string, error) {
+	return marshalElement("rsc_order", b.c)
+}
+
+// Builds a ticket constraint.
+type TicketBuilder struct {
+	c *TicketConstraint
+}
+
+// Allows the resource to run only while the
+// ticket is granted.
+func NewTicket(ticket, rsc string) *TicketBuilder {
+	return &TicketBuilder{&TicketConstraint{
+		Id:     sanitizeId("ticket-" + ticket + "-" + rsc),
+		Ticket: ticket,
+		Rsc:    rsc,
+	}}
+}
+
+// Overrides the generated id.
+func (b *TicketBuilder) Id(id string) *TicketBuilder {
+	b.c.Id = id
+	return b
+}
+
+func (b *TicketBuilder) Role(role string) *TicketBuilder {
+	b.c.RscRole = role
+	return b
+}
+
+// Sets what happens when the ticket is
+// revoked: "stop", "demote", "fence" or
+// "freeze".
+func (b *TicketBuilder) LossPolicy(policy string) *TicketBuilder {
+	b.c.LossPolicy = policy
+	return b
+}
+
+func (b *TicketBuilder) Constraint() *TicketConstraint {
+	return b.c
+}
+
+func (b *TicketBuilder) ToXML() (string, error) {
+	return marshalElement("rsc_ticket", b.c)
+}
+
+func newResource(kind ResourceKind, id string) *Resource {
+	return &Resource{XMLName: xml.Name{Local: string(kind)}, Id: id}
+}
+
+// Sets the pair in the first set, creating the
+// set with the given id if there is none.
+func setNvpair(sets []*AttributeSet, id, name, value string) []*AttributeSet {
+	if len(sets) == 0 {
+		sets = []*AttributeSet{{Id: sanitizeId(id)}}
+	}
+	set := sets[0]
+	for _, nv := range set.Nvpairs {
+		if nv.Name == name {
+			nv.Value = value
+			return sets
+		}
+	}
+	set.Nvpairs = append(set.Nvpairs, &Nvpair{Id: sanitizeId(set.Id + "-" + name), Name: name, Value: value})
+	return sets
+}
+
+// Replaces characters that are not allowed in
+// XML ids with dots, as crm_xml_sanitize_id
+// does for ":" and "#".
+func sanitizeId(id string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '.'
+	}, id)
+}
+
+func marshalBuilt(rsc *Resource) (string, error) {
+	data, err := xml.Marshal(rsc)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func marshalElement(name string, v interface{}) (string, error) {
+	var buf strings.Builder
+	enc := xml.NewEncoder(&buf)
+	if err := enc.EncodeElement(v, xml.StartElement{Name: xml.Name{Local: name}}); err != nil {
+		return "", err
+	}
+	if err := enc.Flush(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
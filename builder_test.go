@@ -0,0 +1,144 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"testing"
+
+	"github.com/ClusterLabs/go-pacemaker/cibxml"
+)
+
+func TestBuildPrimitive(t *testing.T) {
+	p := NewPrimitive("myAddr", "ocf:heartbeat:IPaddr").
+		Param("ip", "192.0.2.10").
+		Param("ip", "192.0.2.11").
+		Meta("target-role", "Stopped").
+		Op("monitor", "300s", OpWithTimeout("20s")).
+		Op("start", "0s")
+	data, err := p.ToXML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<primitive id="myAddr" class="ocf" provider="heartbeat" type="IPaddr">` +
+		`<instance_attributes id="myAddr-instance_attributes"><nvpair id="myAddr-instance_attributes-ip" name="ip" value="192.0.2.11"></nvpair></instance_attributes>` +
+		`<meta_attributes id="myAddr-meta_attributes"><nvpair id="myAddr-meta_attributes-target-role" name="target-role" value="Stopped"></nvpair></meta_attributes>` +
+		`<operations><op id="myAddr-monitor-interval-300s" name="monitor" interval="300s" timeout="20s"></op>` +
+		`<op id="myAddr-start-interval-0s" name="start" interval="0s"></op></operations></primitive>`
+	if data != expected {
+		t.Errorf("Unexpected XML:\n%s\nexpected:\n%s", data, expected)
+	}
+
+	if agent := NewPrimitive("web", "systemd:httpd").Resource().Agent(); agent != "systemd:httpd" {
+		t.Errorf("Unexpected agent %q", agent)
+	}
+}
+
+func TestBuildResources(t *testing.T) {
+	ip := NewPrimitive("ip", "ocf:heartbeat:IPaddr2").Param("ip", "192.0.2.10")
+	web := NewPrimitive("web", "systemd:httpd").Op("monitor", "10s")
+	group := NewGroup("site", ip, web)
+	drbd := NewPrimitive("drbd", "ocf:linbit:drbd").Op("monitor", "10s", OpWithRole("Promoted"))
+	bundle := NewBundle("httpd-bundle").
+		Podman("localhost/httpd").
+		Replicas(3).
+		Network("192.0.2.100", "eth0").
+		Port("80").
+		Storage("/srv/www", "/var/www/html").
+		Storage("/var/log/httpd", "/var/log/httpd").
+		Primitive(NewPrimitive("httpd", "ocf:heartbeat:apache"))
+
+	for _, b := range []ResourceBuilder{group, NewClone(NewPrimitive("ping", "ocf:pacemaker:ping")), NewPromotableClone(drbd), bundle} {
+		checkBuiltIds(t, b.ToXML)
+	}
+
+	clone := NewPromotableClone(drbd).Resource()
+	if clone.Id != "drbd-clone" || !clone.isPromotable() || clone.Children[0] != drbd.Resource() {
+		t.Errorf("Unexpected clone: %+v", clone)
+	}
+	if len(group.Resource().Children) != 2 {
+		t.Errorf("Expected 2 group members")
+	}
+	if b := bundle.Resource(); b.Podman.Replicas != "3" || len(b.Storage) != 2 || b.Storage[1].Id != "httpd-bundle-storage-map-1" {
+		t.Errorf("Unexpected bundle: %+v", b)
+	}
+	if _, err := NewBundle("empty").ToXML(); err == nil {
+		t.Error("Expected error for bundle without container")
+	}
+	for _, child := range []ResourceBuilder{NewClone(group), bundle} {
+		if _, err := NewClone(child).ToXML(); err == nil {
+			t.Errorf("Expected error for clone of %s", child.Resource().Kind())
+		}
+	}
+}
+
+func TestBuildConstraints(t *testing.T) {
+	tests := []struct {
+		build    func() (string, error)
+		expected string
+	}{
+		{
+			NewLocation("myAddr", "c001n01", ScoreInfinity).ToXML,
+			`<rsc_location id="location-myAddr-c001n01-INFINITY" rsc="myAddr" score="INFINITY" node="c001n01"></rsc_location>`,
+		},
+		{
+			NewLocationRule("myAddr", ScoreMinusInfinity).Expr("#uname", "eq", "c001n02").Expr("site", "defined", "").ToXML,
+			`<rsc_location id="location-myAddr--INFINITY" rsc="myAddr"><rule id="location-myAddr--INFINITY-rule" score="-INFINITY" boolean-op="and">` +
+				`<expression id="location-myAddr--INFINITY-rule-expr" attribute="#uname" operation="eq" value="c001n02"></expression>` +
+				`<expression id="location-myAddr--INFINITY-rule-expr-1" attribute="site" operation="defined"></expression></rule></rsc_location>`,
+		},
+		{
+			NewColocation("ip", "drbd-clone", ScoreInfinity).Roles("", "Promoted").ToXML,
+			`<rsc_colocation id="colocation-ip-drbd-clone-INFINITY" rsc="ip" with-rsc="drbd-clone" with-rsc-role="Promoted" score="INFINITY"></rsc_colocation>`,
+		},
+		{
+			NewOrder("drbd-clone", "ip").Actions("promote", "start").Kind("Mandatory").ToXML,
+			`<rsc_order id="order-drbd-clone-ip" first="drbd-clone" then="ip" first-action="promote" then-action="start" kind="Mandatory"></rsc_order>`,
+		},
+		{
+			NewTicket("ticketA", "ip").LossPolicy("stop").ToXML,
+			`<rsc_ticket id="ticket-ticketA-ip" ticket="ticketA" rsc="ip" loss-policy="stop"></rsc_ticket>`,
+		},
+	}
+	for _, test := range tests {
+		data, err := test.build()
+		if err != nil {
+			t.Error(err)
+		} else if data != test.expected {
+			t.Errorf("Unexpected XML:\n%s\nexpected:\n%s", data, test.expected)
+		}
+	}
+
+	if _, err := NewLocationRule("myAddr", 100).ToXML(); err == nil {
+		t.Error("Expected error for rule without expressions")
+	}
+	ban := NewLocationRule("myAddr", ScoreMinusInfinity).Constraint().Id
+	prefer := NewLocationRule("myAddr", 100).Constraint().Id
+	if ban == prefer {
+		t.Errorf("Expected rules with different scores to get different ids, got %q", ban)
+	}
+	if id := NewLocation("rsc:0", "node 1", 100).Constraint().Id; id != "location-rsc.0-node.1-100" {
+		t.Errorf("Expected sanitized id, got %q", id)
+	}
+}
+
+// Checks that every id in the built XML is unique.
+func checkBuiltIds(t *testing.T, build func() (string, error)) {
+	data, err := build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := cibxml.ParseString(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	root.Walk(func(e *cibxml.Element) bool {
+		if id := e.Id(); id != "" {
+			if seen[id] {
+				t.Errorf("Duplicate id %q in %s", id, data)
+			}
+			seen[id] = true
+		}
+		return true
+	})
+}
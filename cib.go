@@ -10,6 +10,12 @@ package pacemaker
 #include <crm/common/util.h>
 #include <crm/common/xml.h>
 #include <crm/common/mainloop.h>
+#include <crm/pengine/status.h>
+#include <pacemaker.h>
+#include <libxml/xmlerror.h>
+#include <errno.h>
+#include <ffi.h>
+#include <stdint.h>
 
 // Flags returned by go_cib_register_notify_callbacks
 // indicating which notifications were actually
@@ -21,14 +27,28 @@ package pacemaker
 extern int go_cib_signon(cib_t* cib, const char* name, enum cib_conn_type type);
 extern int go_cib_signoff(cib_t* cib);
 extern int go_cib_query(cib_t * cib, const char *section, xmlNode ** output_data, int call_options);
-extern unsigned int go_cib_register_notify_callbacks(cib_t * cib);
-extern void go_add_idle_scheduler(GMainLoop* loop);
+extern int go_cib_create(cib_t * cib, const char *section, xmlNode * data, int call_options);
+extern int go_cib_modify(cib_t * cib, const char *section, xmlNode * data, int call_options);
+extern int go_cib_replace(cib_t * cib, const char *section, xmlNode * data, int call_options);
+extern int go_cib_remove(cib_t * cib, const char *section, xmlNode * data, int call_options);
+struct go_cib_notify;
+extern struct go_cib_notify *go_cib_notify_new(cib_t *cib);
+extern void go_cib_notify_free(struct go_cib_notify *notify);
+extern unsigned int go_cib_register_notify_callbacks(cib_t * cib, struct go_cib_notify *notify);
+extern void go_cib_unregister_notify_callbacks(cib_t * cib, struct go_cib_notify *notify);
+extern void go_mainloop_quit(GMainLoop* loop);
+extern void go_cib_perform_call(cib_t *cib, int op, const char *section, xmlNode *data, int options, int timeout, long id);
+extern void go_mainloop_invoke(long id);
+extern xmlNode *go_cib_notify_diff(xmlNode * msg);
+extern int go_cib_apply_patch(xmlNode * msg, xmlNode * input, xmlNode ** output);
+extern xmlNode *go_cib_create_patchset(xmlNode *source, xmlNode *target);
+extern int go_pcmk_simulate(const char *input_file, const char *graph_file, int show_scores, char **scores);
+extern int go_cib_validate(xmlNode *xml);
+extern int go_cib_upgrade(xmlNode *xml, int max, int *best, xmlNode **upgraded);
 
 
 #define F_CIB_UPDATE_RESULT "cib_update_result"
 
-static cib_t *s_cib = NULL;
-
 int go_cib_signon(cib_t* cib, const char* name, enum cib_conn_type type) {
 	int rc;
 	rc = cib->cmds->signon(cib, name, type);
@@ -47,55 +67,303 @@ int go_cib_query(cib_t * cib, const char *section, xmlNode ** output_data, int c
 	return rc;
 }
 
-static void go_cib_destroy_cb(gpointer user_data) {
-	extern void destroyNotifyCallback();
-	destroyNotifyCallback();
+int go_cib_create(cib_t * cib, const char *section, xmlNode * data, int call_options) {
+	int rc;
+	rc = cib->cmds->create(cib, section, data, call_options);
+	return rc;
 }
 
-static void go_cib_notify_cb(const char *event, xmlNode * msg) {
+int go_cib_modify(cib_t * cib, const char *section, xmlNode * data, int call_options) {
 	int rc;
-	rc = pcmk_ok;
+	rc = cib->cmds->modify(cib, section, data, call_options);
+	return rc;
+}
+
+int go_cib_replace(cib_t * cib, const char *section, xmlNode * data, int call_options) {
+	int rc;
+	rc = cib->cmds->replace(cib, section, data, call_options);
+	return rc;
+}
 
-	xmlNode *current_cib;
+int go_cib_remove(cib_t * cib, const char *section, xmlNode * data, int call_options) {
+	int rc;
+	rc = cib->cmds->remove(cib, section, data, call_options);
+	return rc;
+}
 
-	s_cib->cmds->query(s_cib, NULL, &current_cib, cib_scope_local | cib_sync_call);
+// The notify callbacks registered with
+// pacemaker receive no user data, so each
+// connection with subscribers gets its own
+// callback, built as a libffi closure that
+// passes the connection to the Go side.
+struct go_cib_notify {
+	ffi_closure *closure;
+	ffi_cif cif;
+	ffi_type *args[2];
+	void (*callback)(const char *, xmlNode *);
+	cib_t *cib;
+};
 
-	extern void diffNotifyCallback(xmlNode*);
-	diffNotifyCallback(current_cib);
+static void go_cib_notify_cb(ffi_cif *cif, void *ret, void **args, void *user_data) {
+	extern void diffNotifyCallback(cib_t*, xmlNode*);
+	struct go_cib_notify *notify = user_data;
+	diffNotifyCallback(notify->cib, *(xmlNode **)args[1]);
+}
+
+// The destroy callback is passed the
+// connection as its user data.
+static void go_cib_destroy_cb(gpointer user_data) {
+	extern void destroyNotifyCallback(cib_t*);
+	destroyNotifyCallback((cib_t*)user_data);
+}
 
-	free_xml(current_cib);
+void go_cib_notify_free(struct go_cib_notify *notify) {
+	if (notify == NULL) {
+		return;
+	}
+	if (notify->closure != NULL) {
+		ffi_closure_free(notify->closure);
+	}
+	free(notify);
 }
 
+struct go_cib_notify *go_cib_notify_new(cib_t *cib) {
+	struct go_cib_notify *notify = calloc(1, sizeof(struct go_cib_notify));
+	void *code = NULL;
 
-unsigned int go_cib_register_notify_callbacks(cib_t * cib) {
+	if (notify == NULL) {
+		return NULL;
+	}
+	notify->cib = cib;
+	notify->args[0] = &ffi_type_pointer;
+	notify->args[1] = &ffi_type_pointer;
+	notify->closure = ffi_closure_alloc(sizeof(ffi_closure), &code);
+	if (notify->closure == NULL
+	    || ffi_prep_cif(&notify->cif, FFI_DEFAULT_ABI, 2, &ffi_type_void, notify->args) != FFI_OK
+	    || ffi_prep_closure_loc(notify->closure, &notify->cif, go_cib_notify_cb, notify, code) != FFI_OK) {
+		go_cib_notify_free(notify);
+		return NULL;
+	}
+	notify->callback = code;
+	return notify;
+}
+
+unsigned int go_cib_register_notify_callbacks(cib_t * cib, struct go_cib_notify *notify) {
 	int rc;
 	unsigned int flags;
 
-	s_cib = cib;
 	flags = 0;
 
 	rc = cib->cmds->set_connection_dnotify(cib, go_cib_destroy_cb);
 	if (rc == pcmk_ok) {
 		flags |= GO_CIB_NOTIFY_DESTROY;
 	}
-	rc = cib->cmds->del_notify_callback(cib, T_CIB_DIFF_NOTIFY, go_cib_notify_cb);
+	rc = cib->cmds->del_notify_callback(cib, T_CIB_DIFF_NOTIFY, notify->callback);
 	if (rc == pcmk_ok) {
 		flags |= GO_CIB_NOTIFY_ADDREMOVE;
 	}
-	rc = cib->cmds->add_notify_callback(cib, T_CIB_DIFF_NOTIFY, go_cib_notify_cb);
+	rc = cib->cmds->add_notify_callback(cib, T_CIB_DIFF_NOTIFY, notify->callback);
 	if (rc == pcmk_ok) {
 		flags |= GO_CIB_NOTIFY_ADDREMOVE;
 	}
 	return flags;
 }
 
-static gboolean idle_callback(gpointer user_data) {
-	extern void goMainloopSched();
-	goMainloopSched();
+void go_cib_unregister_notify_callbacks(cib_t * cib, struct go_cib_notify *notify) {
+	cib->cmds->del_notify_callback(cib, T_CIB_DIFF_NOTIFY, notify->callback);
+	cib->cmds->set_connection_dnotify(cib, NULL);
+}
+
+xmlNode *go_cib_notify_diff(xmlNode * msg) {
+	int rc;
+
+	rc = pcmk_ok;
+	crm_element_value_int(msg, F_CIB_RC, &rc);
+	if (rc != pcmk_ok) {
+		return NULL;
+	}
+	return get_message_xml(msg, F_CIB_UPDATE_RESULT);
+}
+
+int go_cib_apply_patch(xmlNode * msg, xmlNode * input, xmlNode ** output) {
+	return cib_apply_patch_event(msg, input, output, LOG_TRACE);
+}
+
+// Returns a patchset describing the changes from
+// source to target, or NULL if they are the same.
+xmlNode *go_cib_create_patchset(xmlNode *source, xmlNode *target) {
+	xml_track_changes(target, NULL, target, FALSE);
+	xml_calculate_changes(source, target);
+	return xml_create_patchset(0, source, target, NULL, FALSE);
+}
+
+// Runs the scheduler on the CIB in input_file,
+// writing the transition graph to graph_file if
+// not NULL. With show_scores, the scores are
+// returned as XML in scores, which the caller
+// must free.
+int go_pcmk_simulate(const char *input_file, const char *graph_file, int show_scores, char **scores) {
+	xmlNode *output = NULL;
+	pe_working_set_t *data_set;
+	pcmk_injections_t *injections;
+	unsigned int flags = pcmk_sim_process;
+	int rc;
+
+	data_set = pe_new_working_set();
+	injections = calloc(1, sizeof(pcmk_injections_t));
+	if (data_set == NULL || injections == NULL) {
+		pe_free_working_set(data_set);
+		free(injections);
+		return -ENOMEM;
+	}
+	if (show_scores) {
+		flags |= pcmk_sim_show_scores;
+	}
+	rc = pcmk_simulate(&output, data_set, injections, flags, 0,
+		NULL, (char *)input_file, (char *)graph_file, NULL);
+	pcmk_free_injections(injections);
+	pe_free_working_set(data_set);
+	if (output != NULL) {
+		if (show_scores && scores != NULL) {
+			*scores = dump_xml_unformatted(output);
+		}
+		free_xml(output);
+	}
+	return pcmk_rc2legacy(rc);
+}
+
+extern void validationErrorCallback(int line, char *path, char *message);
+
+static void go_validation_error(void *ctx, xmlErrorPtr error) {
+	xmlChar *path = NULL;
+	int line = error->line;
+
+	if (error->node != NULL) {
+		path = xmlGetNodePath((xmlNodePtr)error->node);
+		if (line == 0) {
+			line = (int)xmlGetLineNo((xmlNodePtr)error->node);
+		}
+	}
+	validationErrorCallback(line, (char *)path, error->message);
+	if (path != NULL) {
+		xmlFree(path);
+	}
+}
+
+// Validates the document against the schema
+// named in its validate-with attribute. Errors
+// are reported to validationErrorCallback. The
+// handler is per thread in libxml2, so it only
+// sees errors raised by this call.
+int go_cib_validate(xmlNode *xml) {
+	gboolean valid;
+
+	xmlSetStructuredErrorFunc(NULL, go_validation_error);
+	valid = validate_xml(xml, NULL, FALSE);
+	xmlSetStructuredErrorFunc(NULL, NULL);
+	return valid;
+}
+
+// Upgrades a copy of the document through the
+// schema transformations, up to the schema with
+// index max, or the latest if max is 0. The
+// index of the schema reached is set in best.
+int go_cib_upgrade(xmlNode *xml, int max, int *best, xmlNode **upgraded) {
+	xmlNode *copy = copy_xml(xml);
+	int rc;
+
+	*best = 0;
+	rc = update_validation(&copy, best, max, TRUE, FALSE);
+	if (rc != pcmk_ok) {
+		free_xml(copy);
+		return rc;
+	}
+	*upgraded = copy;
+	return pcmk_ok;
+}
+
+// Operations for go_cib_perform_call, in the
+// same order as cibOp.
+#define GO_CIB_CALL_CREATE 0
+#define GO_CIB_CALL_MODIFY 1
+#define GO_CIB_CALL_REPLACE 2
+#define GO_CIB_CALL_DELETE 3
+#define GO_CIB_CALL_QUERY 4
+
+static void go_cib_call_cb(xmlNode *msg, int call_id, int rc, xmlNode *output, void *user_data) {
+	extern void callResultCallback(long, int, xmlNode*);
+	callResultCallback((long)(intptr_t)user_data, rc, output);
+}
+
+// Performs a call for callContext. If the call is
+// still in progress, a callback is registered to
+// pass its result to callResultCallback with the
+// given id, otherwise the result is passed at once.
+// Must be called from the main loop, so that the
+// callback is registered before the main loop can
+// dispatch the reply.
+void go_cib_perform_call(cib_t *cib, int op, const char *section, xmlNode *data, int options, int timeout, long id) {
+	extern void callResultCallback(long, int, xmlNode*);
+	xmlNode *output = NULL;
+	int rc = -EINVAL;
+
+	switch (op) {
+	case GO_CIB_CALL_CREATE:
+		rc = cib->cmds->create(cib, section, data, options);
+		break;
+	case GO_CIB_CALL_MODIFY:
+		rc = cib->cmds->modify(cib, section, data, options);
+		break;
+	case GO_CIB_CALL_REPLACE:
+		rc = cib->cmds->replace(cib, section, data, options);
+		break;
+	case GO_CIB_CALL_DELETE:
+		rc = cib->cmds->remove(cib, section, data, options);
+		break;
+	case GO_CIB_CALL_QUERY:
+		rc = cib->cmds->query(cib, section, &output, options);
+		break;
+	}
+	if (rc > 0) {
+		// rc is the id of a call in progress
+		cib->cmds->register_callback_full(cib, rc, timeout, FALSE,
+			(void*)(intptr_t)id, "go_cib_call_cb", go_cib_call_cb, NULL);
+	} else {
+		// File and shadow CIBs complete calls at once
+		callResultCallback(id, rc, output);
+		if (output != NULL) {
+			free_xml(output);
+		}
+	}
+}
+
+static gboolean go_mainloop_invoke_cb(gpointer user_data) {
+	extern void mainloopInvokeCallback(long);
+	mainloopInvokeCallback((long)(intptr_t)user_data);
+	return G_SOURCE_REMOVE;
+}
+
+// Runs mainloopInvokeCallback with the given id
+// from the main loop, or at once if no main loop
+// is running.
+void go_mainloop_invoke(long id) {
+	g_main_context_invoke(NULL, go_mainloop_invoke_cb, (gpointer)(intptr_t)id);
+}
+
+static gboolean quit_callback(gpointer user_data) {
+	g_main_loop_quit((GMainLoop*)user_data);
+	return G_SOURCE_REMOVE;
+}
+
+static void unref_loop(gpointer user_data) {
+	g_main_loop_unref((GMainLoop*)user_data);
 }
 
-void go_add_idle_scheduler(GMainLoop* loop) {
-	g_idle_add(&idle_callback, loop);
+// Stops the loop from within, so that a quit
+// requested before the loop is running is not
+// lost. Safe to call from any thread.
+void go_mainloop_quit(GMainLoop* loop) {
+	g_idle_add_full(G_PRIORITY_DEFAULT, quit_callback, g_main_loop_ref(loop), unref_loop);
 }
 
 */
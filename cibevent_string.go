@@ -4,9 +4,9 @@ package pacemaker
 
 import "fmt"
 
-const _CibEvent_name = "UpdateEventDestroyEvent"
+const _CibEvent_name = "UpdateEventDestroyEventDisconnectedEventReconnectedEvent"
 
-var _CibEvent_index = [...]uint8{0, 11, 23}
+var _CibEvent_index = [...]uint8{0, 11, 23, 40, 56}
 
 func (i CibEvent) String() string {
 	if i < 0 || i >= CibEvent(len(_CibEvent_index)-1) {
@@ -0,0 +1,293 @@
+// The cibxml package provides a pure Go model of CIB XML documents,
+// for reading and writing the CIB without linking against Pacemaker.
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package cibxml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A single attribute of an element.
+type Attr struct {
+	Name  string
+	Value string
+}
+
+// An element of a CIB document. Attributes are
+// kept in document order, and serializing an
+// element writes them back in that order.
+//
+// Comments, processing instructions and
+// whitespace between elements are not kept.
+// Namespace prefixes are kept as part of the
+// name, e.g. "xmlns:xsi".
+type Element struct {
+	Name     string
+	Attrs    []Attr
+	Children []*Element
+	// Character data directly inside the element,
+	// unless it is only whitespace.
+	Text string
+}
+
+// Parses a CIB document, or any fragment of one
+// with a single root element.
+func Parse(data []byte) (*Element, error) {
+	return Decode(bytes.NewReader(data))
+}
+
+// Like Parse, but takes a string.
+func ParseString(data string) (*Element, error) {
+	return Decode(strings.NewReader(data))
+}
+
+// Reads a document from r. See Parse.
+func Decode(r io.Reader) (*Element, error) {
+	d := xml.NewDecoder(r)
+	var root *Element
+	var stack []*Element
+	var text []string
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("cibxml: %s", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			e := &Element{Name: qualified(t.Name)}
+			for _, attr := range t.Attr {
+				e.Attrs = append(e.Attrs, Attr{qualified(attr.Name), attr.Value})
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, e)
+			} else if root != nil {
+				return nil, fmt.Errorf("cibxml: multiple root elements: %s and %s", root.Name, e.Name)
+			} else {
+				root = e
+			}
+			stack = append(stack, e)
+			text = append(text, "")
+		case xml.EndElement:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("cibxml: unexpected end element %s", qualified(t.Name))
+			}
+			e := stack[len(stack)-1]
+			if name := qualified(t.Name); name != e.Name {
+				return nil, fmt.Errorf("cibxml: element %s closed by %s", e.Name, name)
+			}
+			if s := text[len(text)-1]; strings.TrimSpace(s) != "" {
+				e.Text = s
+			}
+			stack = stack[:len(stack)-1]
+			text = text[:len(text)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				text[len(text)-1] += string(t)
+			}
+		}
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("cibxml: unclosed element %s", stack[len(stack)-1].Name)
+	}
+	if root == nil {
+		return nil, fmt.Errorf("cibxml: no root element")
+	}
+	return root, nil
+}
+
+func qualified(name xml.Name) string {
+	if name.Space != "" {
+		return name.Space + ":" + name.Local
+	}
+	return name.Local
+}
+
+// Returns the value of the named attribute,
+// or "" if it is not set.
+func (e *Element) Get(name string) string {
+	value, _ := e.Lookup(name)
+	return value
+}
+
+// Returns the value of the named attribute,
+// and whether it is set.
+func (e *Element) Lookup(name string) (string, bool) {
+	for _, attr := range e.Attrs {
+		if attr.Name == name {
+			return attr.Value, true
+		}
+	}
+	return "", false
+}
+
+// Sets the named attribute. An existing
+// attribute keeps its position, a new one is
+// added last.
+func (e *Element) Set(name, value string) {
+	for i := range e.Attrs {
+		if e.Attrs[i].Name == name {
+			e.Attrs[i].Value = value
+			return
+		}
+	}
+	e.Attrs = append(e.Attrs, Attr{name, value})
+}
+
+// Removes the named attribute, returning
+// whether it was set.
+func (e *Element) Remove(name string) bool {
+	for i := range e.Attrs {
+		if e.Attrs[i].Name == name {
+			e.Attrs = append(e.Attrs[:i], e.Attrs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the id attribute of the element.
+func (e *Element) Id() string {
+	return e.Get("id")
+}
+
+// Returns the first child with the given name,
+// or nil.
+func (e *Element) Child(name string) *Element {
+	for _, c := range e.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Returns all children with the given name.
+func (e *Element) ChildrenNamed(name string) []*Element {
+	var result []*Element
+	for _, c := range e.Children {
+		if c.Name == name {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// Follows a path of child names from the
+// element, e.g. Find("configuration", "resources")
+// on a cib element. Returns nil if any step is
+// missing.
+func (e *Element) Find(path ...string) *Element {
+	for _, name := range path {
+		if e = e.Child(name); e == nil {
+			return nil
+		}
+	}
+	return e
+}
+
+// Returns the first element with the given id
+// in a depth-first search, including the
+// element itself, or nil.
+func (e *Element) FindById(id string) *Element {
+	var found *Element
+	e.Walk(func(el *Element) bool {
+		if el.Id() == id {
+			found = el
+		}
+		return found == nil
+	})
+	return found
+}
+
+// Calls fn for the element and each of its
+// descendants in document order, until fn
+// returns false.
+func (e *Element) Walk(fn func(*Element) bool) bool {
+	if !fn(e) {
+		return false
+	}
+	for _, c := range e.Children {
+		if !c.Walk(fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns a deep copy of the element.
+func (e *Element) Copy() *Element {
+	c := &Element{Name: e.Name, Text: e.Text}
+	if e.Attrs != nil {
+		c.Attrs = append([]Attr(nil), e.Attrs...)
+	}
+	for _, child := range e.Children {
+		c.Children = append(c.Children, child.Copy())
+	}
+	return c
+}
+
+// Returns the element as compact XML.
+func (e *Element) String() string {
+	return string(Marshal(e))
+}
+
+// Serializes the element without any added
+// whitespace.
+func Marshal(e *Element) []byte {
+	var buf bytes.Buffer
+	e.write(&buf, "", "", 0)
+	return buf.Bytes()
+}
+
+// Serializes the element with each child on a
+// new line, starting with prefix and indented
+// by indent per level.
+func MarshalIndent(e *Element, prefix, indent string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(prefix)
+	e.write(&buf, prefix, indent, 0)
+	return buf.Bytes()
+}
+
+func (e *Element) write(buf *bytes.Buffer, prefix, indent string, depth int) {
+	pretty := prefix != "" || indent != ""
+	buf.WriteByte('<')
+	buf.WriteString(e.Name)
+	for _, attr := range e.Attrs {
+		buf.WriteByte(' ')
+		buf.WriteString(attr.Name)
+		buf.WriteString(`="`)
+		xml.EscapeText(buf, []byte(attr.Value))
+		buf.WriteByte('"')
+	}
+	if len(e.Children) == 0 && e.Text == "" {
+		buf.WriteString("/>")
+		return
+	}
+	buf.WriteByte('>')
+	xml.EscapeText(buf, []byte(e.Text))
+	for _, c := range e.Children {
+		if pretty {
+			buf.WriteByte('\n')
+			buf.WriteString(prefix)
+			buf.WriteString(strings.Repeat(indent, depth+1))
+		}
+		c.write(buf, prefix, indent, depth+1)
+	}
+	if pretty && len(e.Children) > 0 {
+		buf.WriteByte('\n')
+		buf.WriteString(prefix)
+		buf.WriteString(strings.Repeat(indent, depth))
+	}
+	buf.WriteString("</")
+	buf.WriteString(e.Name)
+	buf.WriteByte('>')
+}
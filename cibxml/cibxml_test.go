@@ -0,0 +1,94 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package cibxml
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestParseSimple(t *testing.T) {
+	data, err := ioutil.ReadFile("../testdata/simple.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cib, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cib.Name != "cib" || cib.Get("epoch") != "0" || cib.Get("admin_epoch") != "1" {
+		t.Errorf("Unexpected root: %s %v", cib.Name, cib.Attrs)
+	}
+	names := []string{"crm_feature_set", "validate-with", "admin_epoch", "epoch", "num_updates"}
+	for i, attr := range cib.Attrs {
+		if attr.Name != names[i] {
+			t.Errorf("Expected attribute %d to be %s, got %s", i, names[i], attr.Name)
+		}
+	}
+
+	nodes := cib.Find("configuration", "nodes").ChildrenNamed("node")
+	if len(nodes) != 2 || nodes[1].Get("uname") != "c001n02" {
+		t.Errorf("Unexpected nodes: %v", nodes)
+	}
+	if nv := cib.FindById("option-2"); nv == nil || nv.Get("value") != "stop" {
+		t.Errorf("Expected to find option-2, got %v", nv)
+	}
+	if cib.Find("configuration", "missing") != nil {
+		t.Error("Expected nil for missing path")
+	}
+
+	again, err := Parse(Marshal(cib))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cib, again) {
+		t.Error("Expected document to survive a round trip")
+	}
+	indented, err := Parse(MarshalIndent(cib, "", "  "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cib, indented) {
+		t.Error("Expected indented document to survive a round trip")
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	e, err := ParseString(`<op name="monitor" interval="10s" id="op&quot;1">
+  <meta>text &amp; more</meta>
+</op>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Set("interval", "20s")
+	e.Set("timeout", "30s")
+	e.Remove("name")
+	expected := `<op interval="20s" id="op&#34;1" timeout="30s"><meta>text &amp; more</meta></op>`
+	if s := e.String(); s != expected {
+		t.Errorf("Expected %s, got %s", expected, s)
+	}
+	expected = "<op interval=\"20s\" id=\"op&#34;1\" timeout=\"30s\">\n  <meta>text &amp; more</meta>\n</op>"
+	if s := string(MarshalIndent(e, "", "  ")); s != expected {
+		t.Errorf("Expected %s, got %s", expected, s)
+	}
+
+	c := e.Copy()
+	c.Children[0].Text = "changed"
+	if e.Children[0].Text != "text & more" {
+		t.Error("Expected copy to be independent")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, data := range []string{
+		"",
+		"<a><b></a>",
+		"<a/><b/>",
+		"<a>",
+	} {
+		if _, err := ParseString(data); err == nil {
+			t.Errorf("Expected error parsing %q", data)
+		}
+	}
+}
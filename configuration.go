@@ -0,0 +1,479 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// Typed representation of the configuration
+// section of the CIB, as returned by
+// CibDocument.Decode.
+type Configuration struct {
+	CrmConfig       []*AttributeSet `xml:"crm_config>cluster_property_set" json:"crm_config,omitempty"`
+	Nodes           []*Node         `xml:"nodes>node" json:"nodes,omitempty"`
+	Resources       []*Resource     `xml:"-" json:"resources,omitempty"`
+	Constraints     Constraints     `xml:"constraints" json:"constraints,omitempty"`
+	RscDefaults     []*AttributeSet `xml:"rsc_defaults>meta_attributes" json:"rsc_defaults,omitempty"`
+	OpDefaults      []*AttributeSet `xml:"op_defaults>meta_attributes" json:"op_defaults,omitempty"`
+	FencingTopology []*FencingLevel `xml:"fencing-topology>fencing-level" json:"fencing-topology,omitempty"`
+	Alerts          []*Alert        `xml:"alerts>alert" json:"alerts,omitempty"`
+	Tags            []*Tag          `xml:"tags>tag" json:"tags,omitempty"`
+	Acls            *Acls           `xml:"acls" json:"acls,omitempty"`
+}
+
+// The resources section holds resources of
+// different kinds in document order, which
+// encoding/xml cannot express directly.
+func (conf *Configuration) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type plain Configuration
+	var v struct {
+		plain
+		Resources struct {
+			Resources []*Resource `xml:",any"`
+		} `xml:"resources"`
+	}
+	if err := d.DecodeElement(&v, &start); err != nil {
+		return err
+	}
+	*conf = Configuration(v.plain)
+	conf.Resources = v.Resources.Resources
+	return nil
+}
+
+// A set of name-value pairs, such as
+// instance_attributes, meta_attributes,
+// utilization or cluster_property_set.
+// An optional score orders sets against
+// each other and optional rules restrict
+// when the set applies.
+type AttributeSet struct {
+	Id      string    `xml:"id,attr" json:"id"`
+	IdRef   string    `xml:"id-ref,attr,omitempty" json:"id-ref,omitempty"`
+	Score   Score     `xml:"score,attr,omitempty" json:"score,omitempty"`
+	Rules   []*Rule   `xml:"rule" json:"rule,omitempty"`
+	Nvpairs []*Nvpair `xml:"nvpair" json:"nvpair,omitempty"`
+}
+
+type Nvpair struct {
+	Id    string `xml:"id,attr" json:"id"`
+	IdRef string `xml:"id-ref,attr,omitempty" json:"id-ref,omitempty"`
+	Name  string `xml:"name,attr" json:"name"`
+	Value string `xml:"value,attr" json:"value"`
+}
+
+type Rule struct {
+	Id              string            `xml:"id,attr" json:"id"`
+	IdRef           string            `xml:"id-ref,attr,omitempty" json:"id-ref,omitempty"`
+	Score           Score             `xml:"score,attr,omitempty" json:"score,omitempty"`
+	ScoreAttribute  string            `xml:"score-attribute,attr,omitempty" json:"score-attribute,omitempty"`
+	BooleanOp       string            `xml:"boolean-op,attr,omitempty" json:"boolean-op,omitempty"`
+	Role            string            `xml:"role,attr,omitempty" json:"role,omitempty"`
+	Expressions     []*Expression     `xml:"expression" json:"expression,omitempty"`
+	DateExpressions []*DateExpression `xml:"date_expression" json:"date_expression,omitempty"`
+	RscExpressions  []*RscExpression  `xml:"rsc_expression" json:"rsc_expression,omitempty"`
+	OpExpressions   []*OpExpression   `xml:"op_expression" json:"op_expression,omitempty"`
+	Rules           []*Rule           `xml:"rule" json:"rule,omitempty"`
+}
+
+type Expression struct {
+	Id          string `xml:"id,attr" json:"id"`
+	Attribute   string `xml:"attribute,attr" json:"attribute"`
+	Operation   string `xml:"operation,attr" json:"operation"`
+	Value       string `xml:"value,attr,omitempty" json:"value,omitempty"`
+	Type        string `xml:"type,attr,omitempty" json:"type,omitempty"`
+	ValueSource string `xml:"value-source,attr,omitempty" json:"value-source,omitempty"`
+}
+
+type DateExpression struct {
+	Id        string    `xml:"id,attr" json:"id"`
+	Operation string    `xml:"operation,attr" json:"operation"`
+	Start     string    `xml:"start,attr,omitempty" json:"start,omitempty"`
+	End       string    `xml:"end,attr,omitempty" json:"end,omitempty"`
+	Duration  *DateSpec `xml:"duration" json:"duration,omitempty"`
+	DateSpec  *DateSpec `xml:"date_spec" json:"date_spec,omitempty"`
+}
+
+// Used both for date_spec and duration
+// elements. Each field holds a single
+// value or a range such as "1-5".
+type DateSpec struct {
+	Id        string `xml:"id,attr" json:"id"`
+	Years     string `xml:"years,attr,omitempty" json:"years,omitempty"`
+	Months    string `xml:"months,attr,omitempty" json:"months,omitempty"`
+	Weeks     string `xml:"weeks,attr,omitempty" json:"weeks,omitempty"`
+	Monthdays string `xml:"monthdays,attr,omitempty" json:"monthdays,omitempty"`
+	Weekdays  string `xml:"weekdays,attr,omitempty" json:"weekdays,omitempty"`
+	Yeardays  string `xml:"yeardays,attr,omitempty" json:"yeardays,omitempty"`
+	Weekyears string `xml:"weekyears,attr,omitempty" json:"weekyears,omitempty"`
+	Days      string `xml:"days,attr,omitempty" json:"days,omitempty"`
+	Hours     string `xml:"hours,attr,omitempty" json:"hours,omitempty"`
+	Minutes   string `xml:"minutes,attr,omitempty" json:"minutes,omitempty"`
+	Seconds   string `xml:"seconds,attr,omitempty" json:"seconds,omitempty"`
+	Moon      string `xml:"moon,attr,omitempty" json:"moon,omitempty"`
+}
+
+type RscExpression struct {
+	Id       string `xml:"id,attr" json:"id"`
+	Class    string `xml:"class,attr,omitempty" json:"class,omitempty"`
+	Provider string `xml:"provider,attr,omitempty" json:"provider,omitempty"`
+	Type     string `xml:"type,attr,omitempty" json:"type,omitempty"`
+}
+
+type OpExpression struct {
+	Id       string `xml:"id,attr" json:"id"`
+	Name     string `xml:"name,attr" json:"name"`
+	Interval string `xml:"interval,attr,omitempty" json:"interval,omitempty"`
+}
+
+type Node struct {
+	Id                 string          `xml:"id,attr" json:"id"`
+	Uname              string          `xml:"uname,attr" json:"uname"`
+	Type               string          `xml:"type,attr,omitempty" json:"type,omitempty"`
+	Description        string          `xml:"description,attr,omitempty" json:"description,omitempty"`
+	Score              Score           `xml:"score,attr,omitempty" json:"score,omitempty"`
+	InstanceAttributes []*AttributeSet `xml:"instance_attributes" json:"instance_attributes,omitempty"`
+	Utilization        []*AttributeSet `xml:"utilization" json:"utilization,omitempty"`
+}
+
+// The kind of a resource is the name
+// of the XML element defining it.
+type ResourceKind string
+
+const (
+	PrimitiveResource ResourceKind = "primitive"
+	GroupResource     ResourceKind = "group"
+	CloneResource     ResourceKind = "clone"
+	MasterResource    ResourceKind = "master"
+	BundleResource    ResourceKind = "bundle"
+	TemplateResource  ResourceKind = "template"
+)
+
+// A resource of any kind. Which fields are
+// used depends on the kind: primitives and
+// templates have a class and type, groups,
+// clones and masters have children, and
+// bundles have a container definition.
+type Resource struct {
+	XMLName            xml.Name              `json:"-"`
+	Id                 string                `xml:"id,attr" json:"id"`
+	Description        string                `xml:"description,attr,omitempty" json:"description,omitempty"`
+	Class              string                `xml:"class,attr,omitempty" json:"class,omitempty"`
+	Provider           string                `xml:"provider,attr,omitempty" json:"provider,omitempty"`
+	Type               string                `xml:"type,attr,omitempty" json:"type,omitempty"`
+	Template           string                `xml:"template,attr,omitempty" json:"template,omitempty"`
+	InstanceAttributes []*AttributeSet       `xml:"instance_attributes" json:"instance_attributes,omitempty"`
+	MetaAttributes     []*AttributeSet       `xml:"meta_attributes" json:"meta_attributes,omitempty"`
+	Utilization        []*AttributeSet       `xml:"utilization" json:"utilization,omitempty"`
+	Operations         []*Operation          `xml:"operations>op" json:"operations,omitempty"`
+	Docker             *BundleContainer      `xml:"docker" json:"docker,omitempty"`
+	Podman             *BundleContainer      `xml:"podman" json:"podman,omitempty"`
+	Rkt                *BundleContainer      `xml:"rkt" json:"rkt,omitempty"`
+	Network            *BundleNetwork        `xml:"network" json:"network,omitempty"`
+	Storage            []*BundleStorageMount `xml:"storage>storage-mapping" json:"storage,omitempty"`
+	Children           []*Resource           `xml:",any" json:"children,omitempty"`
+	OtherAttrs         []xml.Attr            `xml:",any,attr" json:"-"`
+}
+
+// Returns the kind of resource, e.g.
+// PrimitiveResource or GroupResource.
+func (rsc *Resource) Kind() ResourceKind {
+	return ResourceKind(rsc.XMLName.Local)
+}
+
+// Returns the agent specification of a
+// primitive, e.g. "ocf:heartbeat:IPaddr".
+func (rsc *Resource) Agent() string {
+	if rsc.Provider != "" {
+		return rsc.Class + ":" + rsc.Provider + ":" + rsc.Type
+	}
+	return rsc.Class + ":" + rsc.Type
+}
+
+type Operation struct {
+	Id                 string          `xml:"id,attr" json:"id"`
+	Name               string          `xml:"name,attr" json:"name"`
+	Interval           string          `xml:"interval,attr" json:"interval"`
+	Timeout            string          `xml:"timeout,attr,omitempty" json:"timeout,omitempty"`
+	Role               string          `xml:"role,attr,omitempty" json:"role,omitempty"`
+	OnFail             string          `xml:"on-fail,attr,omitempty" json:"on-fail,omitempty"`
+	Description        string          `xml:"description,attr,omitempty" json:"description,omitempty"`
+	OtherAttrs         []xml.Attr      `xml:",any,attr" json:"-"`
+	InstanceAttributes []*AttributeSet `xml:"instance_attributes" json:"instance_attributes,omitempty"`
+	MetaAttributes     []*AttributeSet `xml:"meta_attributes" json:"meta_attributes,omitempty"`
+}
+
+// Returns the interval of the operation. An
+// operation without an interval is not
+// recurring.
+func (op *Operation) IntervalDuration() (time.Duration, error) {
+	if op.Interval == "" {
+		return 0, nil
+	}
+	return ParseInterval(op.Interval)
+}
+
+// Returns the timeout of the operation, and
+// false if the op element does not set one.
+func (op *Operation) TimeoutDuration() (time.Duration, bool, error) {
+	if op.Timeout == "" {
+		return 0, false, nil
+	}
+	d, err := ParseTimeout(op.Timeout)
+	return d, err == nil, err
+}
+
+type BundleContainer struct {
+	Image           string `xml:"image,attr" json:"image"`
+	Replicas        string `xml:"replicas,attr,omitempty" json:"replicas,omitempty"`
+	ReplicasPerHost string `xml:"replicas-per-host,attr,omitempty" json:"replicas-per-host,omitempty"`
+	PromotedMax     string `xml:"promoted-max,attr,omitempty" json:"promoted-max,omitempty"`
+	Network         string `xml:"network,attr,omitempty" json:"network,omitempty"`
+	RunCommand      string `xml:"run-command,attr,omitempty" json:"run-command,omitempty"`
+	Options         string `xml:"options,attr,omitempty" json:"options,omitempty"`
+}
+
+type BundleNetwork struct {
+	IpRangeStart  string               `xml:"ip-range-start,attr,omitempty" json:"ip-range-start,omitempty"`
+	ControlPort   string               `xml:"control-port,attr,omitempty" json:"control-port,omitempty"`
+	HostInterface string               `xml:"host-interface,attr,omitempty" json:"host-interface,omitempty"`
+	HostNetmask   string               `xml:"host-netmask,attr,omitempty" json:"host-netmask,omitempty"`
+	AddHost       string               `xml:"add-host,attr,omitempty" json:"add-host,omitempty"`
+	PortMappings  []*BundlePortMapping `xml:"port-mapping" json:"port-mapping,omitempty"`
+}
+
+type BundlePortMapping struct {
+	Id           string `xml:"id,attr" json:"id"`
+	Port         string `xml:"port,attr,omitempty" json:"port,omitempty"`
+	InternalPort string `xml:"internal-port,attr,omitempty" json:"internal-port,omitempty"`
+	Range        string `xml:"range,attr,omitempty" json:"range,omitempty"`
+}
+
+type BundleStorageMount struct {
+	Id            string `xml:"id,attr" json:"id"`
+	SourceDir     string `xml:"source-dir,attr,omitempty" json:"source-dir,omitempty"`
+	SourceDirRoot string `xml:"source-dir-root,attr,omitempty" json:"source-dir-root,omitempty"`
+	TargetDir     string `xml:"target-dir,attr" json:"target-dir"`
+	Options       string `xml:"options,attr,omitempty" json:"options,omitempty"`
+}
+
+// The score of a constraint or resource set is
+// nil if it is not set, since leaving it out
+// does not mean the same as a score of 0.
+type Constraints struct {
+	Locations   []*LocationConstraint   `xml:"rsc_location" json:"rsc_location,omitempty"`
+	Colocations []*ColocationConstraint `xml:"rsc_colocation" json:"rsc_colocation,omitempty"`
+	Orders      []*OrderConstraint      `xml:"rsc_order" json:"rsc_order,omitempty"`
+	Tickets     []*TicketConstraint     `xml:"rsc_ticket" json:"rsc_ticket,omitempty"`
+}
+
+type LocationConstraint struct {
+	Id                string         `xml:"id,attr" json:"id"`
+	Rsc               string         `xml:"rsc,attr,omitempty" json:"rsc,omitempty"`
+	RscPattern        string         `xml:"rsc-pattern,attr,omitempty" json:"rsc-pattern,omitempty"`
+	Role              string         `xml:"role,attr,omitempty" json:"role,omitempty"`
+	Score             *Score         `xml:"score,attr,omitempty" json:"score,omitempty"`
+	Node              string         `xml:"node,attr,omitempty" json:"node,omitempty"`
+	ResourceDiscovery string         `xml:"resource-discovery,attr,omitempty" json:"resource-discovery,omitempty"`
+	Rules             []*Rule        `xml:"rule" json:"rule,omitempty"`
+	ResourceSets      []*ResourceSet `xml:"resource_set" json:"resource_set,omitempty"`
+	Lifetime          *Lifetime      `xml:"lifetime,omitempty" json:"lifetime,omitempty"`
+	OtherAttrs        []xml.Attr     `xml:",any,attr" json:"-"`
+}
+
+// The deprecated lifetime of a location
+// constraint: the constraint only applies
+// while one of the rules passes.
+type Lifetime struct {
+	Rules []*Rule `xml:"rule" json:"rule,omitempty"`
+}
+
+type ColocationConstraint struct {
+	Id            string         `xml:"id,attr" json:"id"`
+	Rsc           string         `xml:"rsc,attr,omitempty" json:"rsc,omitempty"`
+	WithRsc       string         `xml:"with-rsc,attr,omitempty" json:"with-rsc,omitempty"`
+	RscRole       string         `xml:"rsc-role,attr,omitempty" json:"rsc-role,omitempty"`
+	WithRscRole   string         `xml:"with-rsc-role,attr,omitempty" json:"with-rsc-role,omitempty"`
+	Score         *Score         `xml:"score,attr,omitempty" json:"score,omitempty"`
+	NodeAttribute string         `xml:"node-attribute,attr,omitempty" json:"node-attribute,omitempty"`
+	Influence     string         `xml:"influence,attr,omitempty" json:"influence,omitempty"`
+	ResourceSets  []*ResourceSet `xml:"resource_set" json:"resource_set,omitempty"`
+	OtherAttrs    []xml.Attr     `xml:",any,attr" json:"-"`
+}
+
+type OrderConstraint struct {
+	Id           string         `xml:"id,attr" json:"id"`
+	First        string         `xml:"first,attr,omitempty" json:"first,omitempty"`
+	Then         string         `xml:"then,attr,omitempty" json:"then,omitempty"`
+	FirstAction  string         `xml:"first-action,attr,omitempty" json:"first-action,omitempty"`
+	ThenAction   string         `xml:"then-action,attr,omitempty" json:"then-action,omitempty"`
+	Kind         string         `xml:"kind,attr,omitempty" json:"kind,omitempty"`
+	Score        *Score         `xml:"score,attr,omitempty" json:"score,omitempty"`
+	Symmetrical  string         `xml:"symmetrical,attr,omitempty" json:"symmetrical,omitempty"`
+	RequireAll   string         `xml:"require-all,attr,omitempty" json:"require-all,omitempty"`
+	ResourceSets []*ResourceSet `xml:"resource_set" json:"resource_set,omitempty"`
+	OtherAttrs   []xml.Attr     `xml:",any,attr" json:"-"`
+}
+
+type TicketConstraint struct {
+	Id           string         `xml:"id,attr" json:"id"`
+	Ticket       string         `xml:"ticket,attr" json:"ticket"`
+	Rsc          string         `xml:"rsc,attr,omitempty" json:"rsc,omitempty"`
+	RscRole      string         `xml:"rsc-role,attr,omitempty" json:"rsc-role,omitempty"`
+	LossPolicy   string         `xml:"loss-policy,attr,omitempty" json:"loss-policy,omitempty"`
+	ResourceSets []*ResourceSet `xml:"resource_set" json:"resource_set,omitempty"`
+}
+
+type ResourceSet struct {
+	Id         string         `xml:"id,attr" json:"id"`
+	IdRef      string         `xml:"id-ref,attr,omitempty" json:"id-ref,omitempty"`
+	Sequential string         `xml:"sequential,attr,omitempty" json:"sequential,omitempty"`
+	RequireAll string         `xml:"require-all,attr,omitempty" json:"require-all,omitempty"`
+	Ordering   string         `xml:"ordering,attr,omitempty" json:"ordering,omitempty"`
+	Action     string         `xml:"action,attr,omitempty" json:"action,omitempty"`
+	Role       string         `xml:"role,attr,omitempty" json:"role,omitempty"`
+	Score      *Score         `xml:"score,attr,omitempty" json:"score,omitempty"`
+	Kind       string         `xml:"kind,attr,omitempty" json:"kind,omitempty"`
+	Resources  []*ResourceRef `xml:"resource_ref" json:"resource_ref,omitempty"`
+}
+
+type ResourceRef struct {
+	Id string `xml:"id,attr" json:"id"`
+}
+
+type FencingLevel struct {
+	Id              string `xml:"id,attr" json:"id"`
+	Index           int    `xml:"index,attr" json:"index"`
+	Target          string `xml:"target,attr,omitempty" json:"target,omitempty"`
+	TargetPattern   string `xml:"target-pattern,attr,omitempty" json:"target-pattern,omitempty"`
+	TargetAttribute string `xml:"target-attribute,attr,omitempty" json:"target-attribute,omitempty"`
+	TargetValue     string `xml:"target-value,attr,omitempty" json:"target-value,omitempty"`
+	Devices         string `xml:"devices,attr" json:"devices"`
+}
+
+type Alert struct {
+	Id                 string            `xml:"id,attr" json:"id"`
+	Path               string            `xml:"path,attr" json:"path"`
+	Description        string            `xml:"description,attr,omitempty" json:"description,omitempty"`
+	InstanceAttributes []*AttributeSet   `xml:"instance_attributes" json:"instance_attributes,omitempty"`
+	MetaAttributes     []*AttributeSet   `xml:"meta_attributes" json:"meta_attributes,omitempty"`
+	Recipients         []*AlertRecipient `xml:"recipient" json:"recipient,omitempty"`
+}
+
+type AlertRecipient struct {
+	Id                 string          `xml:"id,attr" json:"id"`
+	Value              string          `xml:"value,attr" json:"value"`
+	Description        string          `xml:"description,attr,omitempty" json:"description,omitempty"`
+	InstanceAttributes []*AttributeSet `xml:"instance_attributes" json:"instance_attributes,omitempty"`
+	MetaAttributes     []*AttributeSet `xml:"meta_attributes" json:"meta_attributes,omitempty"`
+}
+
+type Tag struct {
+	Id      string    `xml:"id,attr" json:"id"`
+	ObjRefs []*ObjRef `xml:"obj_ref" json:"obj_ref,omitempty"`
+}
+
+type ObjRef struct {
+	Id string `xml:"id,attr" json:"id"`
+}
+
+type Acls struct {
+	Targets []*AclTarget `xml:"acl_target" json:"acl_target,omitempty"`
+	Groups  []*AclTarget `xml:"acl_group" json:"acl_group,omitempty"`
+	Roles   []*AclRole   `xml:"acl_role" json:"acl_role,omitempty"`
+}
+
+// Used both for acl_target and acl_group
+// elements, which only differ in name.
+type AclTarget struct {
+	Id    string        `xml:"id,attr" json:"id"`
+	Name  string        `xml:"name,attr,omitempty" json:"name,omitempty"`
+	Roles []*AclRoleRef `xml:"role" json:"role,omitempty"`
+}
+
+type AclRoleRef struct {
+	Id string `xml:"id,attr" json:"id"`
+}
+
+type AclRole struct {
+	Id          string           `xml:"id,attr" json:"id"`
+	Description string           `xml:"description,attr,omitempty" json:"description,omitempty"`
+	Permissions []*AclPermission `xml:"acl_permission" json:"acl_permission,omitempty"`
+}
+
+type AclPermission struct {
+	Id          string `xml:"id,attr" json:"id"`
+	Kind        string `xml:"kind,attr" json:"kind"`
+	Xpath       string `xml:"xpath,attr,omitempty" json:"xpath,omitempty"`
+	Reference   string `xml:"reference,attr,omitempty" json:"reference,omitempty"`
+	ObjectType  string `xml:"object-type,attr,omitempty" json:"object-type,omitempty"`
+	Attribute   string `xml:"attribute,attr,omitempty" json:"attribute,omitempty"`
+	Description string `xml:"description,attr,omitempty" json:"description,omitempty"`
+}
+
+// Returns the value of the named pair
+// in the set, and whether it was found.
+func (set *AttributeSet) Get(name string) (string, bool) {
+	for _, nv := range set.Nvpairs {
+		if nv.Name == name {
+			return nv.Value, true
+		}
+	}
+	return "", false
+}
+
+// Looks up a resource by id anywhere in
+// the resource tree, including children
+// of groups, clones and bundles.
+func (conf *Configuration) FindResource(id string) *Resource {
+	return findResource(conf.Resources, id)
+}
+
+func findResource(resources []*Resource, id string) *Resource {
+	for _, rsc := range resources {
+		if rsc.Id == id {
+			return rsc
+		}
+		if found := findResource(rsc.Children, id); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// Looks up a node by id or by uname.
+func (conf *Configuration) FindNode(name string) *Node {
+	for _, node := range conf.Nodes {
+		if node.Id == name || node.Uname == name {
+			return node
+		}
+	}
+	return nil
+}
+
+type cibRoot struct {
+	XMLName       xml.Name
+	Configuration *Configuration `xml:"configuration"`
+}
+
+func decodeConfiguration(data []byte) (*Configuration, error) {
+	var root cibRoot
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, &CibError{msg: "Failed to decode configuration: " + err.Error()}
+	}
+	switch root.XMLName.Local {
+	case "cib":
+		if root.Configuration == nil {
+			return nil, &CibError{msg: "No configuration section in CIB"}
+		}
+		return root.Configuration, nil
+	case "configuration":
+		var conf Configuration
+		if err := xml.Unmarshal(data, &conf); err != nil {
+			return nil, &CibError{msg: "Failed to decode configuration: " + err.Error()}
+		}
+		return &conf, nil
+	}
+	return nil, &CibError{msg: "Expected cib or configuration element, got " + root.XMLName.Local}
+}
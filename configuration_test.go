@@ -0,0 +1,103 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func loadConfiguration(t *testing.T, file string) *Configuration {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := decodeConfiguration(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conf
+}
+
+func TestDecodeConfiguration(t *testing.T) {
+	conf := loadConfiguration(t, "testdata/simple.xml")
+
+	if len(conf.Nodes) != 2 || conf.Nodes[1].Uname != "c001n02" {
+		t.Errorf("Unexpected nodes: %v", conf.Nodes)
+	}
+	if len(conf.CrmConfig) != 1 {
+		t.Fatalf("Expected 1 property set, got %d", len(conf.CrmConfig))
+	}
+	if v, ok := conf.CrmConfig[0].Get("no-quorum-policy"); !ok || v != "stop" {
+		t.Errorf("Expected no-quorum-policy=stop, got %v", v)
+	}
+
+	rsc := conf.FindResource("myAddr")
+	if rsc == nil {
+		t.Fatal("Resource myAddr not found")
+	}
+	if rsc.Kind() != PrimitiveResource || rsc.Agent() != "ocf:heartbeat:IPaddr" {
+		t.Errorf("Unexpected resource %v (%v)", rsc.Kind(), rsc.Agent())
+	}
+	if v, _ := rsc.InstanceAttributes[0].Get("ip"); v != "192.0.2.10" {
+		t.Errorf("Expected ip=192.0.2.10, got %v", v)
+	}
+	if len(rsc.Operations) != 1 || rsc.Operations[0].Interval != "300s" {
+		t.Errorf("Unexpected operations: %v", rsc.Operations)
+	}
+
+	if len(conf.Constraints.Locations) != 1 || *conf.Constraints.Locations[0].Score != ScoreInfinity {
+		t.Errorf("Unexpected location constraints: %v", conf.Constraints.Locations)
+	}
+	if v, _ := conf.OpDefaults[0].Get("timeout"); v != "30s" {
+		t.Errorf("Expected op default timeout=30s, got %v", v)
+	}
+}
+
+func TestDecodeResourceTree(t *testing.T) {
+	conf := loadConfiguration(t, "testdata/versioned-resources.xml")
+
+	var kinds []ResourceKind
+	for _, rsc := range conf.Resources {
+		kinds = append(kinds, rsc.Kind())
+	}
+	expected := []ResourceKind{PrimitiveResource, PrimitiveResource, PrimitiveResource, CloneResource, MasterResource, GroupResource}
+	if len(kinds) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, kinds)
+	}
+	for i := range kinds {
+		if kinds[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, kinds)
+		}
+	}
+
+	group := conf.FindResource("grouptest2")
+	if len(group.Children) != 3 || group.Children[2].Id != "gvtest3" {
+		t.Errorf("Unexpected group members: %v", group.Children)
+	}
+
+	rsc := conf.FindResource("vtest4")
+	if rsc == nil {
+		t.Fatal("Resource vtest4 not found")
+	}
+	set := rsc.InstanceAttributes[0]
+	if set.Score != 3 || len(set.Rules) != 1 || set.Rules[0].BooleanOp != "and" || len(set.Rules[0].Expressions) != 2 {
+		t.Errorf("Unexpected rule set: %v", set)
+	}
+	monitor := rsc.Operations[2]
+	interval, err := monitor.IntervalDuration()
+	if err != nil || interval != 10*time.Second {
+		t.Errorf("Expected 10s interval, got %v %v", interval, err)
+	}
+	if timeout, ok, err := monitor.TimeoutDuration(); err != nil || !ok || timeout != 20*time.Second {
+		t.Errorf("Expected 20s timeout, got %v %v %v", timeout, ok, err)
+	}
+	if _, ok, _ := (&Operation{Name: "start"}).TimeoutDuration(); ok {
+		t.Error("Expected no timeout")
+	}
+
+	if len(conf.FencingTopology) != 1 || conf.FencingTopology[0].Index != 1 || conf.FencingTopology[0].Devices != "FencingPass,Fencing" {
+		t.Errorf("Unexpected fencing topology: %v", conf.FencingTopology)
+	}
+}
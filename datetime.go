@@ -0,0 +1,310 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An ISO 8601 duration, as used by date
+// expressions. It cannot be expressed as a
+// time.Duration since the length of a month
+// or year depends on the date it is added to.
+// Weeks are counted as 7 days.
+type ISODuration struct {
+	Years, Months, Days     int
+	Hours, Minutes, Seconds int
+}
+
+// Adds the duration to t as Pacemaker does:
+// years and months first, keeping the day of
+// the month unless the resulting month is
+// shorter, then days and the time of day.
+func (d ISODuration) AddTo(t time.Time) time.Time {
+	months := int(t.Month()) - 1 + d.Months + 12*d.Years
+	year := t.Year() + months/12
+	month := months % 12
+	if month < 0 {
+		month += 12
+		year--
+	}
+	day := t.Day()
+	if n := daysIn(time.Month(month+1), year); day > n {
+		day = n
+	}
+	t = time.Date(year, time.Month(month+1), day, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	t = t.AddDate(0, 0, d.Days)
+	return t.Add(time.Duration(d.Hours)*time.Hour + time.Duration(d.Minutes)*time.Minute + time.Duration(d.Seconds)*time.Second)
+}
+
+// Returns the length of the duration, counting
+// a month as 30 days and a year as 365 days as
+// Pacemaker does for operation intervals.
+func (d ISODuration) Duration() time.Duration {
+	days := d.Years*365 + d.Months*30 + d.Days
+	return time.Duration(days)*24*time.Hour + time.Duration(d.Hours)*time.Hour +
+		time.Duration(d.Minutes)*time.Minute + time.Duration(d.Seconds)*time.Second
+}
+
+// Formats the duration in ISO 8601 notation,
+// e.g. "P1Y2M3DT4H5M6S". The zero duration is
+// "PT0S".
+func (d ISODuration) String() string {
+	var b strings.Builder
+	b.WriteString("P")
+	part := func(n int, unit string) {
+		if n != 0 {
+			b.WriteString(strconv.Itoa(n) + unit)
+		}
+	}
+	part(d.Years, "Y")
+	part(d.Months, "M")
+	part(d.Days, "D")
+	if d.Hours != 0 || d.Minutes != 0 || d.Seconds != 0 || b.Len() == 1 {
+		b.WriteString("T")
+		part(d.Hours, "H")
+		part(d.Minutes, "M")
+		part(d.Seconds, "S")
+		if b.Len() == 2 {
+			b.WriteString("0S")
+		}
+	}
+	return b.String()
+}
+
+var isoDurationRe = regexp.MustCompile(`^P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// Parses an ISO 8601 duration such as
+// "P1Y2M3DT4H5M6S" or "P2W".
+func ParseISODuration(s string) (ISODuration, error) {
+	m := isoDurationRe.FindStringSubmatch(s)
+	if m == nil || s == "P" || strings.HasSuffix(s, "T") {
+		return ISODuration{}, &CibError{msg: "Invalid ISO 8601 duration: " + s}
+	}
+	n := make([]int, len(m))
+	for i := 1; i < len(m); i++ {
+		if m[i] != "" {
+			v, err := strconv.Atoi(m[i])
+			if err != nil {
+				return ISODuration{}, &CibError{msg: "Invalid ISO 8601 duration: " + s}
+			}
+			n[i] = v
+		}
+	}
+	return ISODuration{Years: n[1], Months: n[2], Days: n[3]*7 + n[4], Hours: n[5], Minutes: n[6], Seconds: n[7]}, nil
+}
+
+var (
+	calendarDateRe = regexp.MustCompile(`^(\d{4})-?(\d{2})-?(\d{2})$`)
+	ordinalDateRe  = regexp.MustCompile(`^(\d{4})-?(\d{3})$`)
+	weekDateRe     = regexp.MustCompile(`^(\d{4})-?W(\d{2})-?(\d)$`)
+	timeOfDayRe    = regexp.MustCompile(`^(\d{2}):?(\d{2})(?::?(\d{2}))?(Z|[+-]\d{2}(?::?\d{2})?)?$`)
+)
+
+// Parses an ISO 8601 date and time as Pacemaker
+// accepts them in date expressions: a calendar,
+// ordinal or week date, optionally followed by a
+// time of day separated by a space or "T". A
+// time without an offset is in loc.
+func ParseDateTime(s string, loc *time.Location) (time.Time, error) {
+	invalid := &CibError{msg: "Invalid ISO 8601 date: " + s}
+	s = strings.TrimSpace(s)
+	date, clock := s, ""
+	if i := strings.IndexAny(s, " T"); i >= 0 {
+		date, clock = s[:i], strings.TrimSpace(s[i+1:])
+	}
+
+	var year, month, day int
+	atoi := func(s string) int {
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+	var t time.Time
+	if m := calendarDateRe.FindStringSubmatch(date); m != nil {
+		year, month, day = atoi(m[1]), atoi(m[2]), atoi(m[3])
+		if month < 1 || month > 12 || day < 1 || day > daysIn(time.Month(month), year) {
+			return time.Time{}, invalid
+		}
+		t = time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	} else if m := ordinalDateRe.FindStringSubmatch(date); m != nil {
+		year, day = atoi(m[1]), atoi(m[2])
+		if day < 1 || day > daysInYear(year) {
+			return time.Time{}, invalid
+		}
+		t = time.Date(year, 1, day, 0, 0, 0, 0, time.UTC)
+	} else if m := weekDateRe.FindStringSubmatch(date); m != nil {
+		year, week, weekday := atoi(m[1]), atoi(m[2]), atoi(m[3])
+		if week < 1 || week > isoWeeksIn(year) || weekday < 1 || weekday > 7 {
+			return time.Time{}, invalid
+		}
+		// The 4th of January is always in week 1.
+		jan4 := time.Date(year, 1, 4, 0, 0, 0, 0, time.UTC)
+		monday := jan4.AddDate(0, 0, 1-isoWeekday(jan4))
+		t = monday.AddDate(0, 0, (week-1)*7+weekday-1)
+	} else {
+		return time.Time{}, invalid
+	}
+
+	hour, minute, second := 0, 0, 0
+	if clock != "" {
+		m := timeOfDayRe.FindStringSubmatch(clock)
+		if m == nil {
+			return time.Time{}, invalid
+		}
+		hour, minute, second = atoi(m[1]), atoi(m[2]), atoi(m[3])
+		if hour > 24 || minute > 59 || second > 59 || (hour == 24 && (minute > 0 || second > 0)) {
+			return time.Time{}, invalid
+		}
+		switch offset := m[4]; {
+		case offset == "Z":
+			loc = time.UTC
+		case offset != "":
+			digits := strings.Replace(offset[1:], ":", "", 1)
+			secs := atoi(digits[:2]) * 3600
+			if len(digits) == 4 {
+				secs += atoi(digits[2:]) * 60
+			}
+			if offset[0] == '-' {
+				secs = -secs
+			}
+			loc = time.FixedZone(offset, secs)
+		}
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), hour, minute, second, 0, loc), nil
+}
+
+// Formats a time the way Pacemaker writes dates,
+// e.g. "2019-03-01 15:30:00Z" or
+// "2019-03-01 15:30:00+01:00". The result can be
+// parsed by ParseDateTime.
+func FormatDateTime(t time.Time) string {
+	return t.Format("2006-01-02 15:04:05Z07:00")
+}
+
+func daysIn(month time.Month, year int) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+func daysInYear(year int) int {
+	return time.Date(year, 12, 31, 0, 0, 0, 0, time.UTC).YearDay()
+}
+
+// A year has 53 ISO weeks if it starts on a
+// Thursday, or is a leap year starting on a
+// Wednesday.
+func isoWeeksIn(year int) int {
+	_, week := time.Date(year, 12, 28, 0, 0, 0, 0, time.UTC).ISOWeek()
+	return week
+}
+
+// Returns the ISO weekday: 1 for Monday
+// through 7 for Sunday.
+func isoWeekday(t time.Time) int {
+	if t.Weekday() == time.Sunday {
+		return 7
+	}
+	return int(t.Weekday())
+}
+
+// Parses a timeout or delay as Pacemaker's
+// crm_get_msec does: a whole number followed by
+// an optional unit, defaulting to seconds.
+// Units are matched by prefix and case
+// insensitively, so "ms" and "msec" are
+// milliseconds, "us" and "usec" microseconds,
+// "s" and "sec" seconds, "m" and "min" minutes,
+// and "h" and "hr" hours. Digits after a
+// decimal point are ignored, and the result is
+// truncated to whole milliseconds. Values too
+// large for a time.Duration are clamped to the
+// largest one, where Pacemaker clamps to the
+// largest number of milliseconds.
+func ParseTimeout(s string) (time.Duration, error) {
+	return parseMsec(s, "timeout")
+}
+
+func parseMsec(s string, what string) (time.Duration, error) {
+	invalid := &CibError{msg: "Invalid " + what + ": " + s}
+	num := strings.TrimLeft(s, " \t\r\n")
+	digits := num[:len(num)-len(strings.TrimLeft(num, "0123456789."))]
+	units := strings.ToLower(strings.TrimLeft(num[len(digits):], " \t\r\n"))
+	if i := strings.IndexByte(digits, '.'); i >= 0 {
+		digits = digits[:i]
+	}
+	if digits == "" {
+		return 0, invalid
+	}
+	var multiplier, divisor int64 = 1000, 1
+	switch {
+	case strings.HasPrefix(units, "ms"):
+		multiplier = 1
+	case strings.HasPrefix(units, "us"):
+		multiplier, divisor = 1, 1000
+	case strings.HasPrefix(units, "s"):
+		multiplier = 1000
+	case strings.HasPrefix(units, "m"):
+		multiplier = 60 * 1000
+	case strings.HasPrefix(units, "h"):
+		multiplier = 60 * 60 * 1000
+	case units != "":
+		return 0, invalid
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		if ne, ok := err.(*strconv.NumError); !ok || ne.Err != strconv.ErrRange {
+			return 0, invalid
+		}
+		n = math.MaxInt64
+	}
+	if n > int64(maxDuration/time.Millisecond)/multiplier*divisor {
+		return maxDuration, nil
+	}
+	return time.Duration(n*multiplier/divisor) * time.Millisecond, nil
+}
+
+const maxDuration = time.Duration(1<<63 - 1)
+
+// Parses an operation interval as Pacemaker
+// does: an ISO 8601 duration such as "PT30S",
+// or a timeout as accepted by ParseTimeout.
+func ParseInterval(s string) (time.Duration, error) {
+	if trimmed := strings.TrimSpace(s); strings.HasPrefix(trimmed, "P") {
+		d, err := ParseISODuration(trimmed)
+		if err != nil {
+			return 0, err
+		}
+		return d.Duration(), nil
+	}
+	return parseMsec(s, "interval")
+}
+
+// Formats an interval or timeout in the largest
+// unit that represents it exactly, e.g. "0s",
+// "30s", "10min", "2h" or "1500ms". Durations
+// are truncated to whole milliseconds, the
+// precision Pacemaker keeps. The result can be
+// parsed by ParseTimeout.
+func FormatInterval(d time.Duration) string {
+	d = d.Truncate(time.Millisecond)
+	switch {
+	case d == 0:
+		return "0s"
+	case d%time.Hour == 0:
+		return strconv.FormatInt(int64(d/time.Hour), 10) + "h"
+	case d%time.Minute == 0:
+		return strconv.FormatInt(int64(d/time.Minute), 10) + "min"
+	case d%time.Second == 0:
+		return strconv.FormatInt(int64(d/time.Second), 10) + "s"
+	}
+	return strconv.FormatInt(int64(d/time.Millisecond), 10) + "ms"
+}
+
+// Converts the milliseconds recorded in the
+// operation history to a duration.
+func msecDuration(ms int64) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
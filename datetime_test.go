@@ -0,0 +1,179 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDateTime(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Time
+	}{
+		{"2019-03-01", time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"20190301", time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"2019-060", time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"2019-W09-5", time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"2020-W01-1", time.Date(2019, 12, 30, 0, 0, 0, 0, time.UTC)},
+		{"2019-03-01 12:30:15", time.Date(2019, 3, 1, 12, 30, 15, 0, time.UTC)},
+		{"2019-03-01T12:30Z", time.Date(2019, 3, 1, 12, 30, 0, 0, time.UTC)},
+		{"2019-03-01T12:30:00+02:00", time.Date(2019, 3, 1, 10, 30, 0, 0, time.UTC)},
+		{"2019-03-01 12:30:00-0130", time.Date(2019, 3, 1, 14, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		got, err := ParseDateTime(test.input, time.UTC)
+		if err != nil {
+			t.Errorf("%s: %v", test.input, err)
+		} else if !got.Equal(test.expected) {
+			t.Errorf("%s: expected %s, got %s", test.input, test.expected, got)
+		}
+	}
+	for _, input := range []string{"", "2019-13-01", "2019-02-29", "2019-366", "2019-W53-1", "2019-03-01 25:00", "yesterday"} {
+		if _, err := ParseDateTime(input, time.UTC); err == nil {
+			t.Errorf("Expected error for %q", input)
+		}
+	}
+}
+
+func TestParseISODuration(t *testing.T) {
+	d, err := ParseISODuration("P1Y2M3DT4H5M6S")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2019, 1, 31, 0, 0, 0, 0, time.UTC)
+	if got := d.AddTo(start); !got.Equal(time.Date(2020, 4, 3, 4, 5, 6, 0, time.UTC)) {
+		t.Errorf("Unexpected end %s", got)
+	}
+	if d, err := ParseISODuration("P2W"); err != nil || d.Days != 14 {
+		t.Errorf("Expected 14 days, got %+v %v", d, err)
+	}
+	for _, input := range []string{"P", "PT", "P1H", "1D"} {
+		if _, err := ParseISODuration(input); err == nil {
+			t.Errorf("Expected error for %q", input)
+		}
+	}
+}
+
+func TestISODuration(t *testing.T) {
+	jan31 := time.Date(2019, 1, 31, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		d        ISODuration
+		expected time.Time
+	}{
+		{ISODuration{Months: 1}, time.Date(2019, 2, 28, 12, 0, 0, 0, time.UTC)},
+		{ISODuration{Years: 1, Months: 1}, time.Date(2020, 2, 29, 12, 0, 0, 0, time.UTC)},
+		{ISODuration{Months: 1, Days: 1}, time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)},
+		{ISODuration{Months: -2}, time.Date(2018, 11, 30, 12, 0, 0, 0, time.UTC)},
+		{ISODuration{Hours: 12}, time.Date(2019, 2, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		if got := test.d.AddTo(jan31); !got.Equal(test.expected) {
+			t.Errorf("%s: expected %s, got %s", test.d, test.expected, got)
+		}
+	}
+
+	formats := map[ISODuration]string{
+		{}:                      "PT0S",
+		{Days: 14}:              "P14D",
+		{Years: 1, Seconds: 5}:  "P1YT5S",
+		{Hours: 1, Minutes: 30}: "PT1H30M",
+	}
+	for d, expected := range formats {
+		if d.String() != expected {
+			t.Errorf("Expected %s, got %s", expected, d)
+		}
+		if parsed, err := ParseISODuration(expected); err != nil || parsed != d {
+			t.Errorf("%s: round trip gave %+v %v", expected, parsed, err)
+		}
+	}
+	if d := (ISODuration{Years: 1, Months: 1, Days: 1}).Duration(); d != 396*24*time.Hour {
+		t.Errorf("Unexpected duration %s", d)
+	}
+}
+
+func TestFormatDateTime(t *testing.T) {
+	tests := map[string]time.Time{
+		"2019-03-01 15:30:00Z":      time.Date(2019, 3, 1, 15, 30, 0, 0, time.UTC),
+		"2019-03-01 15:30:00+01:00": time.Date(2019, 3, 1, 15, 30, 0, 0, time.FixedZone("CET", 3600)),
+	}
+	for expected, tm := range tests {
+		if got := FormatDateTime(tm); got != expected {
+			t.Errorf("Expected %s, got %s", expected, got)
+		}
+		if parsed, err := ParseDateTime(expected, time.Local); err != nil || !parsed.Equal(tm) {
+			t.Errorf("%s: round trip gave %s %v", expected, parsed, err)
+		}
+	}
+}
+
+func TestParseInterval(t *testing.T) {
+	tests := map[string]time.Duration{
+		"0":       0,
+		"30":      30 * time.Second,
+		" 30 ":    30 * time.Second,
+		"300s":    300 * time.Second,
+		"20sec":   20 * time.Second,
+		"20 SECS": 20 * time.Second,
+		"500ms":   500 * time.Millisecond,
+		"500msec": 500 * time.Millisecond,
+		"250us":   0,
+		"1500us":  time.Millisecond,
+		"10min":   10 * time.Minute,
+		"10m":     10 * time.Minute,
+		"2h":      2 * time.Hour,
+		"2hr":     2 * time.Hour,
+		"1.5s":    time.Second,
+		"PT1M":    time.Minute,
+		"P1DT1S":  24*time.Hour + time.Second,
+		"P1M":     30 * 24 * time.Hour,
+		"P1W":     7 * 24 * time.Hour,
+	}
+	for input, expected := range tests {
+		if got, err := ParseInterval(input); err != nil || got != expected {
+			t.Errorf("%s: expected %s, got %s %v", input, expected, got, err)
+		}
+	}
+	for _, input := range []string{"", "s", "10x", "-5s", "P", "P1H"} {
+		if _, err := ParseInterval(input); err == nil {
+			t.Errorf("Expected error for %q", input)
+		}
+	}
+	if _, err := ParseTimeout("PT30S"); err == nil {
+		t.Error("Expected ISO 8601 duration to be rejected as timeout")
+	}
+	for _, input := range []string{"99999999999999999h", "99999999999999999999999"} {
+		if d, err := ParseTimeout(input); err != nil || d != time.Duration(math.MaxInt64) {
+			t.Errorf("%s: expected overflowing timeout to be clamped, got %s %v", input, d, err)
+		}
+	}
+	if _, err := ParseTimeout("10x"); err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Errorf("Expected invalid timeout error, got %v", err)
+	}
+}
+
+func TestFormatInterval(t *testing.T) {
+	tests := map[time.Duration]string{
+		0:                       "0s",
+		30 * time.Second:        "30s",
+		90 * time.Second:        "90s",
+		10 * time.Minute:        "10min",
+		2 * time.Hour:           "2h",
+		1500 * time.Millisecond: "1500ms",
+	}
+	for d, expected := range tests {
+		got := FormatInterval(d)
+		if got != expected {
+			t.Errorf("%s: expected %s, got %s", d, expected, got)
+		}
+		if parsed, err := ParseTimeout(got); err != nil || parsed != d {
+			t.Errorf("%s: round trip gave %s %v", got, parsed, err)
+		}
+	}
+	if got := FormatInterval(1250 * time.Microsecond); got != "1ms" {
+		t.Errorf("Expected truncation to whole milliseconds, got %s", got)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+)
+
+// The kind of change described by an
+// entry in a CIB patchset.
+type ChangeOperation string
+
+const (
+	ChangeCreate ChangeOperation = "create"
+	ChangeDelete ChangeOperation = "delete"
+	ChangeModify ChangeOperation = "modify"
+	ChangeMove   ChangeOperation = "move"
+)
+
+// A patchset describing an update to the CIB,
+// as delivered to subscribers.
+//
+// Changes are only available for patchsets in
+// format 2, which all current versions of
+// Pacemaker produce. For the legacy format 1,
+// only the versions and the raw XML are set.
+type CibDiff struct {
+	Format  int
+	Source  CibVersion
+	Target  CibVersion
+	Changes []*CibChange
+	Xml     string
+}
+
+// A single change in a patchset. Path is an
+// XPath expression identifying the element
+// that was changed, or for ChangeCreate, the
+// parent of the element that was created.
+type CibChange struct {
+	Operation  ChangeOperation
+	Path       string
+	Position   int
+	Attributes []*AttributeChange
+	// For ChangeCreate, the created element. For
+	// ChangeModify, the element after the change.
+	Xml string
+}
+
+// A changed attribute in a ChangeModify entry.
+// Operation is either "set" or "unset".
+type AttributeChange struct {
+	Name      string
+	Operation string
+	Value     string
+}
+
+// Returns the paths of elements that were
+// created by the patchset, in the same form
+// as the paths of other changes, e.g.
+// /cib/configuration/constraints/rsc_location[@id='loc1'].
+func (diff *CibDiff) Added() []string {
+	var result []string
+	for _, change := range diff.Changes {
+		if change.Operation == ChangeCreate {
+			result = append(result, change.createdPath())
+		}
+	}
+	return result
+}
+
+// Returns the paths of elements that were
+// removed by the patchset.
+func (diff *CibDiff) Removed() []string {
+	return diff.paths(ChangeDelete)
+}
+
+// Returns the paths of elements that were
+// modified or moved by the patchset.
+func (diff *CibDiff) Changed() []string {
+	return append(diff.paths(ChangeModify), diff.paths(ChangeMove)...)
+}
+
+func (diff *CibDiff) paths(op ChangeOperation) []string {
+	var result []string
+	for _, change := range diff.Changes {
+		if change.Operation == op {
+			result = append(result, change.Path)
+		}
+	}
+	return result
+}
+
+// Returns the path of the element created by
+// a ChangeCreate, from the path of its parent
+// and the name and id of the element.
+func (change *CibChange) createdPath() string {
+	decoder := xml.NewDecoder(strings.NewReader(change.Xml))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return change.Path
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			path := change.Path + "/" + start.Name.Local
+			for _, attr := range start.Attr {
+				if attr.Name.Local == "id" {
+					path += "[@id='" + attr.Value + "']"
+				}
+			}
+			return path
+		}
+	}
+}
+
+type xmlVersion struct {
+	AdminEpoch int32 `xml:"admin_epoch,attr"`
+	Epoch      int32 `xml:"epoch,attr"`
+	NumUpdates int32 `xml:"num_updates,attr"`
+}
+
+type xmlInner struct {
+	Inner string `xml:",innerxml"`
+}
+
+type xmlDiff struct {
+	Format  string     `xml:"format,attr"`
+	Source  xmlVersion `xml:"version>source"`
+	Target  xmlVersion `xml:"version>target"`
+	Changes []struct {
+		Operation  string `xml:"operation,attr"`
+		Path       string `xml:"path,attr"`
+		Position   string `xml:"position,attr"`
+		Attributes []struct {
+			Name      string `xml:"name,attr"`
+			Operation string `xml:"operation,attr"`
+			Value     string `xml:"value,attr"`
+		} `xml:"change-list>change-attr"`
+		Result  xmlInner `xml:"change-result"`
+		Created string   `xml:",innerxml"`
+	} `xml:"change"`
+	Removed xmlVersion `xml:"diff-removed>cib"`
+	Added   xmlVersion `xml:"diff-added>cib"`
+}
+
+func parseCibDiff(data string) (*CibDiff, error) {
+	var v xmlDiff
+	if err := xml.Unmarshal([]byte(data), &v); err != nil {
+		return nil, &CibError{msg: "Failed to parse patchset: " + err.Error()}
+	}
+	diff := &CibDiff{Format: 1, Xml: data}
+	if v.Format != "" {
+		format, err := strconv.Atoi(v.Format)
+		if err != nil {
+			return nil, &CibError{msg: "Invalid patchset format: " + v.Format}
+		}
+		diff.Format = format
+	}
+	if diff.Format < 2 {
+		diff.Source = CibVersion(v.Removed)
+		diff.Target = CibVersion(v.Added)
+		return diff, nil
+	}
+
+	diff.Source = CibVersion(v.Source)
+	diff.Target = CibVersion(v.Target)
+	for _, c := range v.Changes {
+		change := &CibChange{
+			Operation: ChangeOperation(c.Operation),
+			Path:      c.Path,
+		}
+		if c.Position != "" {
+			change.Position, _ = strconv.Atoi(c.Position)
+		}
+		switch change.Operation {
+		case ChangeCreate:
+			change.Xml = strings.TrimSpace(c.Created)
+		case ChangeModify:
+			change.Xml = strings.TrimSpace(c.Result.Inner)
+			for _, attr := range c.Attributes {
+				change.Attributes = append(change.Attributes, &AttributeChange{attr.Name, attr.Operation, attr.Value})
+			}
+		}
+		diff.Changes = append(diff.Changes, change)
+	}
+	return diff, nil
+}
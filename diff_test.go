@@ -0,0 +1,82 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"testing"
+)
+
+const patchsetV2 = `<diff format="2">
+  <version>
+    <source admin_epoch="0" epoch="56" num_updates="10"/>
+    <target admin_epoch="0" epoch="57" num_updates="0"/>
+  </version>
+  <change operation="create" path="/cib/configuration/constraints" position="0">
+    <rsc_location id="loc1" rsc="gctvanas-vip" node="node1" score="100"/>
+  </change>
+  <change operation="modify" path="/cib/status/node_state[@id='node1']">
+    <change-list>
+      <change-attr name="crmd" operation="set" value="offline"/>
+      <change-attr name="expected" operation="unset"/>
+    </change-list>
+    <change-result>
+      <node_state id="node1" uname="node1" crmd="offline"/>
+    </change-result>
+  </change>
+  <change operation="delete" path="/cib/configuration/resources/primitive[@id='gctvanas-lvm']"/>
+  <change operation="move" path="/cib/configuration/resources/primitive[@id='gctvanas-vip']" position="1"/>
+</diff>`
+
+func TestParseCibDiff(t *testing.T) {
+	diff, err := parseCibDiff(patchsetV2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.Format != 2 {
+		t.Errorf("Expected format 2, got %d", diff.Format)
+	}
+	if diff.Source.String() != "0:56:10" || diff.Target.String() != "0:57:0" {
+		t.Errorf("Unexpected versions %s -> %s", diff.Source.String(), diff.Target.String())
+	}
+	if len(diff.Changes) != 4 {
+		t.Fatalf("Expected 4 changes, got %d", len(diff.Changes))
+	}
+
+	created := diff.Changes[0]
+	if created.Operation != ChangeCreate || created.Xml != `<rsc_location id="loc1" rsc="gctvanas-vip" node="node1" score="100"/>` {
+		t.Errorf("Unexpected create: %+v", created)
+	}
+	modified := diff.Changes[1]
+	if len(modified.Attributes) != 2 || modified.Attributes[0].Value != "offline" || modified.Attributes[1].Operation != "unset" {
+		t.Errorf("Unexpected modify: %+v", modified)
+	}
+	if modified.Xml != `<node_state id="node1" uname="node1" crmd="offline"/>` {
+		t.Errorf("Unexpected modify result: %s", modified.Xml)
+	}
+	if diff.Changes[3].Position != 1 {
+		t.Errorf("Unexpected move position: %d", diff.Changes[3].Position)
+	}
+
+	if added := diff.Added(); len(added) != 1 || added[0] != "/cib/configuration/constraints/rsc_location[@id='loc1']" {
+		t.Errorf("Unexpected added paths: %v", added)
+	}
+	if removed := diff.Removed(); len(removed) != 1 {
+		t.Errorf("Unexpected removed paths: %v", removed)
+	}
+	if changed := diff.Changed(); len(changed) != 2 {
+		t.Errorf("Unexpected changed paths: %v", changed)
+	}
+}
+
+func TestParseCibDiffV1(t *testing.T) {
+	diff, err := parseCibDiff(`<diff crm_feature_set="3.0.7">
+  <diff-removed><cib admin_epoch="1" epoch="2" num_updates="3"/></diff-removed>
+  <diff-added><cib admin_epoch="1" epoch="2" num_updates="4"/></diff-added>
+</diff>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.Format != 1 || diff.Source.String() != "1:2:3" || diff.Target.String() != "1:2:4" || len(diff.Changes) != 0 {
+		t.Errorf("Unexpected diff: %+v", diff)
+	}
+}
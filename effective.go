@@ -0,0 +1,230 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"time"
+)
+
+// A resolved attribute value, and the id of the
+// CIB element it was taken from: an nvpair, or
+// an op for attributes set directly on it.
+type EffectiveValue struct {
+	Value  string
+	Source string
+}
+
+// The resolved settings of an operation of a
+// resource. Attributes holds the attributes of
+// the op element (e.g. "timeout", "on-fail")
+// merged with its meta attributes and the
+// operation defaults; Parameters holds its
+// instance attributes, such as
+// OCF_CHECK_LEVEL.
+type EffectiveOperation struct {
+	Id         string
+	Name       string
+	Interval   string
+	Attributes map[string]EffectiveValue
+	Parameters map[string]EffectiveValue
+}
+
+// The resolved configuration of a primitive
+// resource on a node. Only values set in the
+// configuration are included; the built-in
+// defaults of Pacemaker and of the resource
+// agent are not.
+type EffectiveResource struct {
+	Id         string
+	Node       string
+	Parameters map[string]EffectiveValue
+	Meta       map[string]EffectiveValue
+	Operations []*EffectiveOperation
+}
+
+// Resolves the instance parameters, meta
+// attributes and operation settings of a
+// primitive on a node, in the order of
+// precedence Pacemaker applies: the resource
+// itself, then its template, which Pacemaker
+// expands into the resource, then the groups,
+// clones and bundles containing it, and last
+// the resource and operation defaults. Rules
+// are evaluated for the node at the current
+// time, without transient node attributes.
+func (conf *Configuration) EffectiveResource(id string, node string) (*EffectiveResource, error) {
+	ctx, err := NewRuleContext(conf, nil, node, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	return conf.effectiveResource(id, ctx)
+}
+
+func (conf *Configuration) effectiveResource(id string, ctx *RuleContext) (*EffectiveResource, error) {
+	path := resourcePath(conf.Resources, id)
+	if len(path) == 0 {
+		return nil, &CibError{msg: "No resource named " + id}
+	}
+	rsc := path[len(path)-1]
+	if rsc.Kind() != PrimitiveResource {
+		return nil, &CibError{msg: "Resource " + id + " is not a primitive"}
+	}
+	var template *Resource
+	if rsc.Template != "" {
+		template = conf.FindResource(rsc.Template)
+		if template == nil || template.Kind() != TemplateResource {
+			return nil, &CibError{msg: "No template named " + rsc.Template}
+		}
+	}
+
+	rctx := *ctx
+	rctx.ResourceClass, rctx.ResourceProvider, rctx.ResourceType = rsc.Class, rsc.Provider, rsc.Type
+	if template != nil {
+		if rctx.ResourceClass == "" {
+			rctx.ResourceClass = template.Class
+		}
+		if rctx.ResourceProvider == "" {
+			rctx.ResourceProvider = template.Provider
+		}
+		if rctx.ResourceType == "" {
+			rctx.ResourceType = template.Type
+		}
+	}
+
+	result := &EffectiveResource{
+		Id:         id,
+		Node:       ctx.NodeAttributes["#uname"],
+		Parameters: make(map[string]EffectiveValue),
+		Meta:       make(map[string]EffectiveValue),
+	}
+
+	levels := [][]*AttributeSet{rsc.InstanceAttributes}
+	if template != nil {
+		levels = append(levels, template.InstanceAttributes)
+	}
+	if err := mergeAttributeSets(result.Parameters, levels, &rctx); err != nil {
+		return nil, err
+	}
+
+	levels = [][]*AttributeSet{rsc.MetaAttributes}
+	if template != nil {
+		levels = append(levels, template.MetaAttributes)
+	}
+	for i := len(path) - 2; i >= 0; i-- {
+		levels = append(levels, path[i].MetaAttributes)
+	}
+	levels = append(levels, conf.RscDefaults)
+	if err := mergeAttributeSets(result.Meta, levels, &rctx); err != nil {
+		return nil, err
+	}
+
+	rctx.ResourceParams = effectiveValues(result.Parameters)
+	rctx.ResourceMeta = effectiveValues(result.Meta)
+	ops := rsc.Operations
+	if template != nil {
+		ops = mergeTemplateOperations(template.Operations, rsc.Operations)
+	}
+	for _, op := range ops {
+		eop, err := conf.effectiveOperation(op, &rctx)
+		if err != nil {
+			return nil, err
+		}
+		result.Operations = append(result.Operations, eop)
+	}
+	return result, nil
+}
+
+func (conf *Configuration) effectiveOperation(op *Operation, ctx *RuleContext) (*EffectiveOperation, error) {
+	interval, err := op.IntervalDuration()
+	if err != nil {
+		return nil, err
+	}
+	octx := *ctx
+	octx.OpName, octx.OpInterval = op.Name, interval
+
+	eop := &EffectiveOperation{
+		Id:         op.Id,
+		Name:       op.Name,
+		Interval:   op.Interval,
+		Attributes: make(map[string]EffectiveValue),
+		Parameters: make(map[string]EffectiveValue),
+	}
+	set := func(name, value string) {
+		if value != "" {
+			eop.Attributes[name] = EffectiveValue{value, op.Id}
+		}
+	}
+	set("timeout", op.Timeout)
+	set("role", op.Role)
+	set("on-fail", op.OnFail)
+	for _, attr := range op.OtherAttrs {
+		set(attr.Name.Local, attr.Value)
+	}
+	levels := [][]*AttributeSet{op.MetaAttributes, conf.OpDefaults}
+	if err := mergeAttributeSets(eop.Attributes, levels, &octx); err != nil {
+		return nil, err
+	}
+	if err := mergeAttributeSets(eop.Parameters, [][]*AttributeSet{op.InstanceAttributes}, &octx); err != nil {
+		return nil, err
+	}
+	return eop, nil
+}
+
+// Adds the values of each level of attribute
+// sets that are not already set by a previous
+// level.
+func mergeAttributeSets(values map[string]EffectiveValue, levels [][]*AttributeSet, ctx *RuleContext) error {
+	for _, sets := range levels {
+		nvs, err := EvaluateAttributeSets(sets, ctx)
+		if err != nil {
+			return err
+		}
+		for name, nv := range nvs {
+			if _, ok := values[name]; !ok {
+				values[name] = EffectiveValue{nv.Value, nv.Id}
+			}
+		}
+	}
+	return nil
+}
+
+func effectiveValues(values map[string]EffectiveValue) map[string]string {
+	result := make(map[string]string)
+	for name, v := range values {
+		result[name] = v.Value
+	}
+	return result
+}
+
+// An operation of the resource replaces the
+// operation of the template with the same key,
+// see templateOpKey.
+func mergeTemplateOperations(template []*Operation, ops []*Operation) []*Operation {
+	var result []*Operation
+	for _, top := range template {
+		overridden := false
+		for _, op := range ops {
+			if templateOpKey(op) == templateOpKey(top) {
+				overridden = true
+				break
+			}
+		}
+		if !overridden {
+			result = append(result, top)
+		}
+	}
+	return append(result, ops...)
+}
+
+// Operations are matched by name and role, as
+// in Pacemaker's template_op_key, where the
+// roles an operation runs in by default are
+// all the same as no role.
+func templateOpKey(op *Operation) string {
+	role := op.Role
+	switch role {
+	case "", "Started", "Slave", "Unpromoted":
+		role = "Unknown"
+	}
+	return op.Name + "-" + role
+}
@@ -0,0 +1,101 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"strings"
+	"testing"
+)
+
+func checkEffective(t *testing.T, what string, values map[string]EffectiveValue, expected map[string]EffectiveValue) {
+	if len(values) != len(expected) {
+		t.Errorf("%s: expected %d values, got %v", what, len(expected), values)
+	}
+	for name, v := range expected {
+		if values[name] != v {
+			t.Errorf("%s: expected %s=%+v, got %+v", what, name, v, values[name])
+		}
+	}
+}
+
+func TestEffectiveResource(t *testing.T) {
+	conf := loadConfiguration(t, "testdata/effective.xml")
+
+	rsc, err := conf.EffectiveResource("web", "node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsc.Node != "node1" {
+		t.Errorf("Expected node1, got %s", rsc.Node)
+	}
+	checkEffective(t, "parameters", rsc.Parameters, map[string]EffectiveValue{
+		"port":       {"8080", "web-rack-a-port"},
+		"statusurl":  {"http://localhost/status", "web-statusurl"},
+		"configfile": {"/etc/httpd/conf/httpd.conf", "web-template-configfile"},
+	})
+	checkEffective(t, "meta", rsc.Meta, map[string]EffectiveValue{
+		"target-role":         {"Started", "web-group-target-role"},
+		"resource-stickiness": {"50", "web-template-stickiness"},
+		"failure-timeout":     {"60s", "web-template-failure-timeout"},
+		"migration-threshold": {"3", "rsc_defaults-options-migration-threshold"},
+	})
+
+	if len(rsc.Operations) != 3 {
+		t.Fatalf("Expected 3 operations, got %d", len(rsc.Operations))
+	}
+	ops := make(map[string]*EffectiveOperation)
+	for _, op := range rsc.Operations {
+		ops[op.Id] = op
+	}
+	if ops["web-template-monitor-10s"] != nil {
+		t.Error("Expected template monitor to be replaced")
+	}
+	checkEffective(t, "start", ops["web-template-start-0"].Attributes, map[string]EffectiveValue{
+		"timeout":        {"40s", "web-template-start-0"},
+		"record-pending": {"true", "op_defaults-options-record-pending"},
+	})
+	checkEffective(t, "monitor", ops["web-monitor-10s"].Attributes, map[string]EffectiveValue{
+		"on-fail":        {"restart", "web-monitor-10s"},
+		"timeout":        {"60s", "op_defaults-monitor-timeout"},
+		"record-pending": {"true", "op_defaults-options-record-pending"},
+	})
+	checkEffective(t, "monitor parameters", ops["web-monitor-10s"].Parameters, map[string]EffectiveValue{
+		"OCF_CHECK_LEVEL": {"10", "web-monitor-10s-depth"},
+	})
+	checkEffective(t, "stop", ops["web-stop-0"].Attributes, map[string]EffectiveValue{
+		"timeout":        {"90s", "web-stop-0-timeout"},
+		"record-pending": {"true", "op_defaults-options-record-pending"},
+	})
+
+	rsc, err = conf.EffectiveResource("web", "node2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := rsc.Parameters["port"]; v.Value != "80" || v.Source != "web-template-port" {
+		t.Errorf("Expected template port on node2, got %+v", v)
+	}
+
+	for _, id := range []string{"missing", "web-group", "web-template"} {
+		if _, err := conf.EffectiveResource(id, "node1"); err == nil {
+			t.Errorf("Expected error for %s", id)
+		}
+	}
+}
+
+func TestMergeTemplateOperations(t *testing.T) {
+	template := []*Operation{
+		{Id: "t-monitor", Name: "monitor", Interval: "10s"},
+		{Id: "t-monitor-promoted", Name: "monitor", Interval: "11s", Role: "Promoted"},
+		{Id: "t-start", Name: "start", Interval: "0"},
+	}
+	ops := []*Operation{
+		{Id: "r-monitor", Name: "monitor", Interval: "30s", Role: "Started"},
+	}
+	var ids []string
+	for _, op := range mergeTemplateOperations(template, ops) {
+		ids = append(ids, op.Id)
+	}
+	if strings.Join(ids, " ") != "t-monitor-promoted t-start r-monitor" {
+		t.Errorf("Unexpected operations: %v", ids)
+	}
+}
@@ -0,0 +1,343 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Typed representation of a complete CIB
+// document, used as the JSON encoding of
+// CibDocument.
+//
+// The JSON encoding of all typed models
+// uses the XML element and attribute names
+// of the CIB as keys. Nested sections are
+// named after the wrapping element (e.g.
+// "crm_config", "operations"), empty values
+// are omitted, and each resource carries a
+// "kind" key with its element name.
+type CibContents struct {
+	XMLName       xml.Name       `xml:"cib" json:"-"`
+	AdminEpoch    int32          `xml:"admin_epoch,attr" json:"admin_epoch"`
+	Epoch         int32          `xml:"epoch,attr" json:"epoch"`
+	NumUpdates    int32          `xml:"num_updates,attr" json:"num_updates"`
+	ValidateWith  string         `xml:"validate-with,attr,omitempty" json:"validate-with,omitempty"`
+	CrmFeatureSet string         `xml:"crm_feature_set,attr,omitempty" json:"crm_feature_set,omitempty"`
+	HaveQuorum    string         `xml:"have-quorum,attr,omitempty" json:"have-quorum,omitempty"`
+	DcUuid        string         `xml:"dc-uuid,attr,omitempty" json:"dc-uuid,omitempty"`
+	Configuration *Configuration `xml:"configuration" json:"configuration"`
+	Status        *Status        `xml:"status" json:"status"`
+}
+
+// Returns the CIB version of the contents.
+func (contents *CibContents) Version() *CibVersion {
+	return &CibVersion{contents.AdminEpoch, contents.Epoch, contents.NumUpdates}
+}
+
+// Returns the contents as CIB XML.
+func (contents *CibContents) ToXML() (string, error) {
+	data, err := xml.Marshal(contents)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Returns the configuration as CIB XML,
+// suitable for passing to Cib.Replace
+// with the "configuration" section.
+func (conf *Configuration) ToXML() (string, error) {
+	data, err := xml.Marshal(conf)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// The crm_config, nodes, resources and
+// constraints sections are required by the
+// schema, so they are always written.
+func (conf *Configuration) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start = xml.StartElement{Name: xml.Name{Local: "configuration"}}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	required := []xmlSection{
+		{"crm_config", "cluster_property_set", conf.CrmConfig, true},
+		{"nodes", "node", conf.Nodes, true},
+		{"resources", "", conf.Resources, true},
+	}
+	optional := []xmlSection{
+		{"rsc_defaults", "meta_attributes", conf.RscDefaults, false},
+		{"op_defaults", "meta_attributes", conf.OpDefaults, false},
+		{"fencing-topology", "fencing-level", conf.FencingTopology, false},
+		{"alerts", "alert", conf.Alerts, false},
+		{"tags", "tag", conf.Tags, false},
+	}
+	for _, s := range required {
+		if err := s.encode(e); err != nil {
+			return err
+		}
+	}
+	err := e.EncodeElement(&conf.Constraints, xml.StartElement{Name: xml.Name{Local: "constraints"}})
+	if err != nil {
+		return err
+	}
+	for _, s := range optional {
+		if err := s.encode(e); err != nil {
+			return err
+		}
+	}
+	if conf.Acls != nil {
+		if err := e.EncodeElement(conf.Acls, xml.StartElement{Name: xml.Name{Local: "acls"}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// A wrapping element containing each item of
+// a slice. If element is empty, the items name
+// themselves (as resources do).
+type xmlSection struct {
+	name     string
+	element  string
+	items    interface{}
+	required bool
+}
+
+func (s xmlSection) encode(e *xml.Encoder) error {
+	v := reflect.ValueOf(s.items)
+	if v.Len() == 0 && !s.required {
+		return nil
+	}
+	start := xml.StartElement{Name: xml.Name{Local: s.name}}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for i := 0; i < v.Len(); i++ {
+		var err error
+		if s.element == "" {
+			err = e.Encode(v.Index(i).Interface())
+		} else {
+			err = e.EncodeElement(v.Index(i).Interface(), xml.StartElement{Name: xml.Name{Local: s.element}})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// encoding/xml writes the wrapping element of
+// a nested path even when the slice is empty,
+// which the schema does not allow for the
+// operations and storage elements. The element
+// is named after the kind of resource.
+func (rsc *Resource) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type plain Resource
+	type operations struct {
+		Operations []*Operation `xml:"op"`
+	}
+	type storage struct {
+		Storage []*BundleStorageMount `xml:"storage-mapping"`
+	}
+	v := struct {
+		*plain
+		Operations *operations `xml:"operations,omitempty"`
+		Storage    *storage    `xml:"storage,omitempty"`
+	}{plain: (*plain)(rsc)}
+	if len(rsc.Operations) > 0 {
+		v.Operations = &operations{rsc.Operations}
+	}
+	if len(rsc.Storage) > 0 {
+		v.Storage = &storage{rsc.Storage}
+	}
+	if rsc.XMLName.Local != "" {
+		start.Name = rsc.XMLName
+	}
+	return e.EncodeElement(v, start)
+}
+
+// Attributes of a resource, operation or
+// constraint that have no dedicated field are
+// encoded as a JSON object under "attributes".
+// An attribute in a namespace is keyed as
+// "{namespace}name".
+func (rsc *Resource) MarshalJSON() ([]byte, error) {
+	type plain Resource
+	return marshalWithAttrs(struct {
+		Kind ResourceKind `json:"kind"`
+		*plain
+	}{rsc.Kind(), (*plain)(rsc)}, rsc.OtherAttrs)
+}
+
+func (rsc *Resource) UnmarshalJSON(data []byte) error {
+	type plain Resource
+	v := struct {
+		Kind ResourceKind `json:"kind"`
+		*plain
+	}{plain: (*plain)(rsc)}
+	if err := unmarshalWithAttrs(data, &v, &rsc.OtherAttrs); err != nil {
+		return err
+	}
+	if v.Kind == "" {
+		return &CibError{msg: "Resource " + rsc.Id + " has no kind"}
+	}
+	rsc.XMLName = xml.Name{Local: string(v.Kind)}
+	return nil
+}
+
+func (op *Operation) MarshalJSON() ([]byte, error) {
+	type plain Operation
+	return marshalWithAttrs((*plain)(op), op.OtherAttrs)
+}
+
+func (op *Operation) UnmarshalJSON(data []byte) error {
+	type plain Operation
+	return unmarshalWithAttrs(data, (*plain)(op), &op.OtherAttrs)
+}
+
+func (c *LocationConstraint) MarshalJSON() ([]byte, error) {
+	type plain LocationConstraint
+	return marshalWithAttrs((*plain)(c), c.OtherAttrs)
+}
+
+func (c *LocationConstraint) UnmarshalJSON(data []byte) error {
+	type plain LocationConstraint
+	return unmarshalWithAttrs(data, (*plain)(c), &c.OtherAttrs)
+}
+
+func (c *ColocationConstraint) MarshalJSON() ([]byte, error) {
+	type plain ColocationConstraint
+	return marshalWithAttrs((*plain)(c), c.OtherAttrs)
+}
+
+func (c *ColocationConstraint) UnmarshalJSON(data []byte) error {
+	type plain ColocationConstraint
+	return unmarshalWithAttrs(data, (*plain)(c), &c.OtherAttrs)
+}
+
+func (c *OrderConstraint) MarshalJSON() ([]byte, error) {
+	type plain OrderConstraint
+	return marshalWithAttrs((*plain)(c), c.OtherAttrs)
+}
+
+func (c *OrderConstraint) UnmarshalJSON(data []byte) error {
+	type plain OrderConstraint
+	return unmarshalWithAttrs(data, (*plain)(c), &c.OtherAttrs)
+}
+
+// Encodes v, which must encode as a JSON
+// object, with attrs added under "attributes".
+// v must not have a MarshalJSON method of its
+// own, or the encoding would recurse.
+func marshalWithAttrs(v interface{}, attrs []xml.Attr) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(attrs) == 0 {
+		return data, err
+	}
+	values := make(map[string]string)
+	for _, attr := range attrs {
+		values[attrKey(attr.Name)] = attr.Value
+	}
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	// Replace the closing brace of the object
+	// with the attributes.
+	buf := append([]byte(nil), data[:len(data)-1]...)
+	if len(buf) > 1 {
+		buf = append(buf, ',')
+	}
+	buf = append(buf, `"attributes":`...)
+	buf = append(buf, encoded...)
+	return append(buf, '}'), nil
+}
+
+// Decodes data into v, and the "attributes"
+// object into attrs.
+// The attributes are sorted by name, since the
+// order of a JSON object is not kept.
+func unmarshalWithAttrs(data []byte, v interface{}, attrs *[]xml.Attr) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	var other struct {
+		Attributes map[string]string `json:"attributes"`
+	}
+	if err := json.Unmarshal(data, &other); err != nil {
+		return err
+	}
+	*attrs = nil
+	for key, value := range other.Attributes {
+		*attrs = append(*attrs, xml.Attr{Name: attrName(key), Value: value})
+	}
+	sort.Slice(*attrs, func(i, j int) bool {
+		return attrKey((*attrs)[i].Name) < attrKey((*attrs)[j].Name)
+	})
+	return nil
+}
+
+// Returns the key of an attribute in the
+// "attributes" object.
+func attrKey(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return "{" + name.Space + "}" + name.Local
+}
+
+func attrName(key string) xml.Name {
+	if strings.HasPrefix(key, "{") {
+		if end := strings.Index(key, "}"); end > 0 {
+			return xml.Name{Space: key[1:end], Local: key[end+1:]}
+		}
+	}
+	return xml.Name{Local: key}
+}
+
+func decodeCib(data []byte) (*CibContents, error) {
+	var contents CibContents
+	if err := xml.Unmarshal(data, &contents); err != nil {
+		return nil, &CibError{msg: "Failed to decode CIB: " + err.Error()}
+	}
+	if contents.Status == nil {
+		contents.Status = &Status{}
+	}
+	return &contents, nil
+}
+
+// Encodes a complete CIB as CibContents, and
+// a configuration or status section as
+// Configuration or Status respectively.
+func encodeJSON(data []byte) ([]byte, error) {
+	var root struct {
+		XMLName xml.Name
+	}
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, &CibError{msg: "Failed to decode CIB: " + err.Error()}
+	}
+	var v interface{}
+	var err error
+	switch root.XMLName.Local {
+	case "cib":
+		v, err = decodeCib(data)
+	case "configuration":
+		v, err = decodeConfiguration(data)
+	case "status":
+		v, err = decodeStatus(data)
+	default:
+		return nil, &CibError{msg: "Cannot encode " + root.XMLName.Local + " as JSON"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
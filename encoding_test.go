@@ -0,0 +1,150 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfigurationJSONRoundTrip(t *testing.T) {
+	for _, file := range []string{"testdata/simple.xml", "testdata/versioned-resources.xml", "testdata/exit-reason.xml"} {
+		conf := loadConfiguration(t, file)
+
+		data, err := json.Marshal(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fromJSON Configuration
+		if err := json.Unmarshal(data, &fromJSON); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(conf, &fromJSON) {
+			t.Errorf("%s: configuration changed after JSON round trip", file)
+		}
+
+		xml, err := fromJSON.ToXML()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fromXML, err := decodeConfiguration([]byte(xml))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(conf, fromXML) {
+			t.Errorf("%s: configuration changed after XML round trip:\n%s", file, xml)
+		}
+	}
+}
+
+func TestCibJSON(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/exit-reason.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := encodeJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded["epoch"] != float64(56) || decoded["validate-with"] != "pacemaker-2.6" {
+		t.Errorf("Unexpected CIB attributes: %v", decoded)
+	}
+	if !strings.Contains(string(encoded), `"transition-magic":"0:7;37:35:0:681b3ca7-f83d-4396-a249-d6d80e0efe16"`) {
+		t.Errorf("Expected transition-magic to be encoded as a string")
+	}
+	if !strings.Contains(string(encoded), `"kind":"master"`) {
+		t.Errorf("Expected resources to carry their kind")
+	}
+
+	var contents CibContents
+	if err := json.Unmarshal(encoded, &contents); err != nil {
+		t.Fatal(err)
+	}
+	original, err := decodeCib(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents.XMLName = original.XMLName
+	if !reflect.DeepEqual(original, &contents) {
+		t.Errorf("CIB changed after JSON round trip")
+	}
+}
+
+func TestConfigurationToXMLRequiredSections(t *testing.T) {
+	xml, err := (&Configuration{}).ToXML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "<configuration><crm_config></crm_config><nodes></nodes><resources></resources><constraints></constraints></configuration>"
+	if xml != expected {
+		t.Errorf("Expected %s, got %s", expected, xml)
+	}
+}
+
+func TestConfigurationJSONKeepsAttributes(t *testing.T) {
+	data := `<configuration><crm_config/><nodes/><resources>` +
+		`<primitive id="a" class="ocf" provider="heartbeat" type="Dummy" critical="false"/>` +
+		`<primitive id="b" class="ocf" provider="heartbeat" type="Dummy"/></resources><constraints>` +
+		`<rsc_location id="loc" rsc="a" node="node1" score="100" future="1"><lifetime><rule id="loc-life" score="0"><date_expression id="loc-life-expr" operation="lt" end="2030-01-01"/></rule></lifetime></rsc_location>` +
+		`<rsc_colocation id="col" rsc="a" with-rsc="b" score="INFINITY" influence="false" rsc-instance="1"/>` +
+		`<rsc_order id="ord" first="a" then="b" require-all="false" first-instance="1"/>` +
+		`</constraints></configuration>`
+	conf, err := decodeConfiguration([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := json.Marshal(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromJSON Configuration
+	if err := json.Unmarshal(encoded, &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	xml, err := fromJSON.ToXML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, attr := range []string{`critical="false"`, `future="1"`, `<lifetime><rule id="loc-life"`, `influence="false"`, `rsc-instance="1"`, `require-all="false"`, `first-instance="1"`} {
+		if !strings.Contains(xml, attr) {
+			t.Errorf("Expected %s after JSON round trip:\n%s", attr, xml)
+		}
+	}
+}
+
+func TestJSONKeepsAttributeNamespace(t *testing.T) {
+	data := `<configuration><crm_config/><nodes/><resources>` +
+		`<primitive id="a" class="ocf" provider="heartbeat" type="Dummy" xmlns:x="urn:x" x:note="1" note="2"/>` +
+		`</resources><constraints/></configuration>`
+	conf, err := decodeConfiguration([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := json.Marshal(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(encoded), `"{urn:x}note":"1"`) {
+		t.Errorf("Expected namespaced attribute in %s", encoded)
+	}
+	var fromJSON Configuration
+	if err := json.Unmarshal(encoded, &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	attrs := make(map[xml.Name]string)
+	for _, attr := range fromJSON.Resources[0].OtherAttrs {
+		attrs[attr.Name] = attr.Value
+	}
+	if attrs[xml.Name{Space: "urn:x", Local: "note"}] != "1" || attrs[xml.Name{Local: "note"}] != "2" {
+		t.Errorf("Unexpected attributes after JSON round trip: %v", fromJSON.Resources[0].OtherAttrs)
+	}
+}
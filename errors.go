@@ -0,0 +1,126 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// Error type returned by the functions in this package.
+//
+// Errors reported by Pacemaker carry its return
+// code, and can be matched against the sentinel
+// errors below using errors.Is:
+//
+//	if errors.Is(err, pacemaker.ErrNoSuchObject) {
+//		...
+//	}
+type CibError struct {
+	// The Pacemaker return code, a negative errno
+	// or pcmk_err_* value, or 0 for errors raised
+	// by this package.
+	Code int
+	// The symbolic name of Code, e.g. "ENXIO".
+	Name string
+	// The operation that failed, e.g. "query" or
+	// "modify", if any.
+	Operation string
+	msg       string
+	err       error
+}
+
+func (e *CibError) Error() string {
+	msg := e.msg
+	if e.Code != 0 {
+		msg = fmt.Sprintf("%d: %s %s", e.Code, e.Name, e.msg)
+	}
+	if e.Operation != "" {
+		return e.Operation + ": " + msg
+	}
+	return msg
+}
+
+// Returns the underlying error, such as
+// context.DeadlineExceeded, if any.
+func (e *CibError) Unwrap() error {
+	return e.err
+}
+
+// Returns the description of the error,
+// without the code or operation.
+func (e *CibError) Message() string {
+	return e.msg
+}
+
+var (
+	// The connection to the CIB is closed or
+	// could not be established.
+	ErrNotConnected = errors.New("not connected to the CIB")
+	// The section, XPath expression or object
+	// does not exist.
+	ErrNoSuchObject = errors.New("no such object")
+	// An object or shadow CIB with the same name
+	// already exists.
+	ErrExists = errors.New("already exists")
+	// The update was based on an older version
+	// of the CIB than the current one.
+	ErrVersionConflict = errors.New("CIB version conflict")
+	// The user is not allowed to perform the
+	// operation, e.g. because of ACLs.
+	ErrPermissionDenied = errors.New("permission denied")
+	// The call did not complete in time.
+	ErrTimeout = errors.New("CIB call timed out")
+	// The document does not validate against its
+	// schema, or could not be upgraded.
+	ErrSchemaValidation = errors.New("schema validation failed")
+)
+
+// Pacemaker's custom return codes, from
+// crm/common/results.h.
+const (
+	pcmkErrSchemaValidation = 203
+	pcmkErrTransformFailed  = 204
+	pcmkErrOldData          = 205
+	pcmkErrDiffResync       = 207
+	pcmkErrCibModified      = 208
+)
+
+var sentinelCodes = map[error][]int{
+	ErrNotConnected:     {-int(syscall.ENOTCONN), -int(syscall.ECONNREFUSED)},
+	ErrNoSuchObject:     {-int(syscall.ENXIO)},
+	ErrExists:           {-int(syscall.EEXIST)},
+	ErrVersionConflict:  {-pcmkErrOldData, -pcmkErrDiffResync, -pcmkErrCibModified},
+	ErrPermissionDenied: {-int(syscall.EACCES), -int(syscall.EPERM)},
+	ErrTimeout:          {-int(syscall.ETIME), -int(syscall.ETIMEDOUT)},
+	ErrSchemaValidation: {-pcmkErrSchemaValidation, -pcmkErrTransformFailed},
+}
+
+// Reports whether the error matches one of
+// the sentinel errors of this package.
+func (e *CibError) Is(target error) bool {
+	for _, code := range sentinelCodes[target] {
+		if e.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Returned for calls on a closed connection.
+func errClosed(op string) *CibError {
+	return &CibError{Code: -int(syscall.ENOTCONN), Name: "ENOTCONN", Operation: op, msg: "Connection is closed"}
+}
+
+// Returned when the context of a call is done
+// before the call completes.
+func contextError(op string, err error) *CibError {
+	e := &CibError{Operation: op, msg: err.Error(), err: err}
+	if err == context.DeadlineExceeded {
+		e.Code = -int(syscall.ETIME)
+		e.Name = "ETIME"
+	}
+	return e
+}
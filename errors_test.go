@@ -0,0 +1,74 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+)
+
+func TestCibErrorIs(t *testing.T) {
+	err := &CibError{Code: -int(syscall.ENXIO), Name: "ENXIO", Operation: "query", msg: "No such device or address"}
+	if !errors.Is(err, ErrNoSuchObject) {
+		t.Error("Expected ENXIO to be ErrNoSuchObject")
+	}
+	if errors.Is(err, ErrNotConnected) {
+		t.Error("Expected ENXIO not to be ErrNotConnected")
+	}
+	wrapped := fmt.Errorf("loading resources: %w", err)
+	if !errors.Is(wrapped, ErrNoSuchObject) {
+		t.Error("Expected wrapped error to match")
+	}
+	var cibErr *CibError
+	if !errors.As(wrapped, &cibErr) || cibErr.Operation != "query" {
+		t.Error("Expected to unwrap CibError")
+	}
+	expected := fmt.Sprintf("query: %d: ENXIO No such device or address", -int(syscall.ENXIO))
+	if err.Error() != expected {
+		t.Errorf("Expected %q, got %q", expected, err.Error())
+	}
+
+	conflict := &CibError{Code: -pcmkErrOldData, Operation: "replace"}
+	if !errors.Is(conflict, ErrVersionConflict) {
+		t.Error("Expected pcmk_err_old_data to be ErrVersionConflict")
+	}
+	if !errors.Is(errClosed("modify"), ErrNotConnected) {
+		t.Error("Expected closed connection to be ErrNotConnected")
+	}
+	if !errors.Is(&CibError{Code: -int(syscall.EACCES)}, ErrPermissionDenied) {
+		t.Error("Expected EACCES to be ErrPermissionDenied")
+	}
+	if !errors.Is(&CibError{Code: -int(syscall.EEXIST)}, ErrExists) {
+		t.Error("Expected EEXIST to be ErrExists")
+	}
+	if !errors.Is(&CibError{Code: -pcmkErrTransformFailed}, ErrSchemaValidation) {
+		t.Error("Expected pcmk_err_transform_failed to be ErrSchemaValidation")
+	}
+
+	plain := &CibError{msg: "Invalid transition key: x"}
+	if plain.Error() != "Invalid transition key: x" {
+		t.Errorf("Unexpected message %q", plain.Error())
+	}
+	for _, sentinel := range []error{ErrNotConnected, ErrNoSuchObject, ErrExists, ErrVersionConflict, ErrPermissionDenied, ErrSchemaValidation} {
+		if errors.Is(plain, sentinel) {
+			t.Errorf("Expected %v not to match %v", plain, sentinel)
+		}
+	}
+}
+
+func TestContextError(t *testing.T) {
+	err := contextError("query", context.DeadlineExceeded)
+	if !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline to be a timeout, got %v", err)
+	}
+	err = contextError("query", context.Canceled)
+	if errors.Is(err, ErrTimeout) || !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected cancellation not to be a timeout, got %v", err)
+	}
+	if err.Error() != "query: context canceled" {
+		t.Errorf("Unexpected message %q", err.Error())
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"context"
+	"sync"
+)
+
+// Number of events buffered by Events
+// unless WithBuffer is given.
+const DefaultEventBuffer = 64
+
+// An event delivered by Cib.Events.
+type Event struct {
+	Type CibEvent
+	// The patchset for an UpdateEvent, or the
+	// version gap for a ReconnectedEvent.
+	Diff *CibDiff
+	// The complete CIB after an UpdateEvent or
+	// ReconnectedEvent, only set when
+	// subscribing WithDocument.
+	// The receiver owns the document and must
+	// close it.
+	Document *CibDocument
+	// The number of updates that were dropped
+	// before this event because the consumer
+	// did not keep up.
+	Dropped int
+}
+
+// Sets the number of events to buffer
+// for a consumer of Events. Not accepted
+// by SubscribeDiff, which does not buffer.
+func WithBuffer(size int) func(*SubscribeConfig) {
+	return func(config *SubscribeConfig) {
+		config.buffer = size
+	}
+}
+
+// Events subscribes to the CIB and returns a
+// channel delivering each update, and a
+// DestroyEvent if the connection is lost. For
+// a connection opened WithReconnect, a lost
+// connection is instead reported with a
+// DisconnectedEvent and a ReconnectedEvent.
+// The channel is closed after a DestroyEvent,
+// or when the context is cancelled.
+//
+// Events are never allowed to block the main
+// loop. If the consumer falls behind and the
+// buffer is full, further updates are dropped
+// until there is room again, and the next
+// event delivered reports how many were lost
+// in Dropped. A consumer seeing a non-zero
+// Dropped count should query the CIB again
+// rather than rely on the patchsets it missed.
+// Events other than updates, such as a
+// DestroyEvent, are always delivered.
+func (cib *Cib) Events(ctx context.Context, options ...func(*SubscribeConfig)) (<-chan *Event, error) {
+	config := SubscribeConfig{buffer: DefaultEventBuffer}
+	for _, opt := range options {
+		opt(&config)
+	}
+	if config.buffer < 1 {
+		config.buffer = 1
+	}
+	q := &eventQueue{
+		limit:  config.buffer,
+		notify: make(chan struct{}, 1),
+	}
+	sub, err := cib.subscribe(func(event CibEvent, diff *CibDiff, doc *CibDocument) {
+		ev := &Event{Type: event, Diff: diff}
+		if doc != nil {
+			ev.Document = doc.Copy()
+		}
+		q.push(ev)
+	}, config.withDocument)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan *Event)
+	go func() {
+		defer close(ch)
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case <-ctx.Done():
+				q.discard()
+				return
+			case <-q.notify:
+			}
+			for {
+				ev, last := q.pop()
+				if ev == nil {
+					break
+				}
+				select {
+				case ch <- ev:
+				case <-ctx.Done():
+					if ev.Document != nil {
+						ev.Document.Close()
+					}
+					q.discard()
+					return
+				}
+				if last {
+					q.discard()
+					return
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// Events waiting to be received, filled from
+// the main loop and drained by the goroutine
+// feeding the channel returned by Events.
+type eventQueue struct {
+	lock    sync.Mutex
+	events  []*Event
+	limit   int
+	dropped int
+	notify  chan struct{}
+	// Set once nobody receives the events any
+	// more.
+	closed bool
+}
+
+func (q *eventQueue) push(ev *Event) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		if ev.Document != nil {
+			ev.Document.Close()
+		}
+		return
+	}
+	if len(q.events) >= q.limit && ev.Type == UpdateEvent {
+		q.dropped++
+		if ev.Document != nil {
+			ev.Document.Close()
+		}
+		return
+	}
+	ev.Dropped = q.dropped
+	q.dropped = 0
+	q.events = append(q.events, ev)
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Returns the next event, or nil if there are
+// none, and whether it ends the subscription.
+func (q *eventQueue) pop() (*Event, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if len(q.events) == 0 {
+		return nil, false
+	}
+	ev := q.events[0]
+	q.events[0] = nil
+	q.events = q.events[1:]
+	return ev, ev.Type == DestroyEvent
+}
+
+func (q *eventQueue) discard() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for _, ev := range q.events {
+		if ev.Document != nil {
+			ev.Document.Close()
+		}
+	}
+	q.events = nil
+	q.closed = true
+}
@@ -0,0 +1,27 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"testing"
+)
+
+func TestEventQueue(t *testing.T) {
+	q := &eventQueue{limit: 1, notify: make(chan struct{}, 1)}
+	q.push(&Event{Type: UpdateEvent})
+	q.push(&Event{Type: UpdateEvent})
+	q.push(&Event{Type: DisconnectedEvent})
+	if ev, _ := q.pop(); ev == nil || ev.Type != UpdateEvent {
+		t.Fatalf("Expected update, got %+v", ev)
+	}
+	if ev, last := q.pop(); ev == nil || ev.Type != DisconnectedEvent || ev.Dropped != 1 || last {
+		t.Fatalf("Expected disconnect after a dropped update, got %+v", ev)
+	}
+
+	q.discard()
+	q.push(&Event{Type: ReconnectedEvent})
+	q.push(&Event{Type: DestroyEvent})
+	if ev, _ := q.pop(); ev != nil {
+		t.Errorf("Expected no events after discard, got %+v", ev)
+	}
+}
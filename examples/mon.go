@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -17,37 +18,52 @@ var user = flag.String("user", "hacluster", "remote user to connect as")
 var password = flag.String("password", "", "remote password to connect with")
 var encrypted = flag.Bool("encrypted", false, "set if remote connection is encrypted")
 
-func listenToCib(cib *pacemaker.Cib, restarter chan int) {
-	_, err := cib.Subscribe(func(event pacemaker.CibEvent, doc *pacemaker.CibDocument) {
-		if event == pacemaker.UpdateEvent {
-			fmt.Printf("\n")
-			fmt.Printf("event: %s\n", event)
-			if *verbose {
-				fmt.Printf("cib: %s\n", doc.ToString())
-			}
-		} else {
-			log.Printf("lost connection: %s\n", event)
-			restarter <- 1
-		}
-	})
+func listenToCib(cib *pacemaker.Cib) {
+	var options []func(*pacemaker.SubscribeConfig)
+	if *verbose {
+		options = append(options, pacemaker.WithDocument)
+	}
+	events, err := cib.Events(context.Background(), options...)
 	if err != nil {
-		log.Printf("Failed to subscribe to CIB: %s", err)
+		log.Fatalf("Failed to subscribe to CIB: %s", err)
 	}
+	go func() {
+		for event := range events {
+			switch event.Type {
+			case pacemaker.UpdateEvent:
+				fmt.Printf("\n")
+				fmt.Printf("event: %s %s -> %s\n", event.Type, event.Diff.Source.String(), event.Diff.Target.String())
+				if event.Dropped > 0 {
+					fmt.Printf("dropped %d events\n", event.Dropped)
+				}
+			case pacemaker.ReconnectedEvent:
+				log.Printf("reconnected: %s -> %s\n", event.Diff.Source.String(), event.Diff.Target.String())
+			default:
+				log.Printf("lost connection: %s\n", event.Type)
+			}
+			if event.Document != nil {
+				fmt.Printf("cib: %s\n", event.Document.ToString())
+				event.Document.Close()
+			}
+		}
+		log.Fatal("CIB connection closed")
+	}()
 }
 
 func connectToCib() (*pacemaker.Cib, error) {
 	var cib *pacemaker.Cib
 	var err error
+	reconnect := pacemaker.WithReconnect(pacemaker.DefaultReconnectPolicy)
 	// connect to CIB in various ways
 	// 1 using a file
 	if *file != "" {
-		cib, err = pacemaker.OpenCib(pacemaker.FromFile(*file))
+		cib, err = pacemaker.OpenCib(pacemaker.FromFile(*file), reconnect)
 		// 2 using a remote server
 	} else if *remoteSrv != "" {
-		cib, err = pacemaker.OpenCib(pacemaker.FromRemote(*remoteSrv, *user, *password, *port, *encrypted))
+		cib, err = pacemaker.OpenCib(pacemaker.FromRemote(*remoteSrv, *user, *password, *port, *encrypted), reconnect)
 		// 3 assuming cib is local to the binary
 	} else {
-		cib, err = pacemaker.OpenCib()
+		cib, err = pacemaker.OpenCib(reconnect)
 	}
 	if err != nil {
 		log.Print("Failed to open CIB")
@@ -57,6 +73,7 @@ func connectToCib() (*pacemaker.Cib, error) {
 	doc, err := cib.Query()
 	if err != nil {
 		log.Print("Failed to query CIB")
+		cib.Close()
 		return nil, err
 	}
 	defer doc.Close()
@@ -69,32 +86,14 @@ func connectToCib() (*pacemaker.Cib, error) {
 func main() {
 	flag.Parse()
 
-	restarter := make(chan int)
-
+	// Once connected, lost connections are
+	// handled by WithReconnect.
 	cib, err := connectToCib()
-	if err != nil {
+	for err != nil {
 		log.Printf("Failed in connectToCib: %s", err)
-	} else {
-		listenToCib(cib, restarter)
-	}
-	go pacemaker.Mainloop()
-	state := 0
-	for {
-		if state == 0 {
-			state = <-restarter
-		} else if state == 1 {
-			if cib != nil {
-				cib.Close()
-				cib = nil
-			}
-			cib, err = connectToCib()
-			if err != nil {
-				log.Printf("Failed in connectToCib: %s", err)
-				time.Sleep(5 * time.Second)
-			} else {
-				listenToCib(cib, restarter)
-				state = 0
-			}
-		}
+		time.Sleep(5 * time.Second)
+		cib, err = connectToCib()
 	}
+	listenToCib(cib)
+	pacemaker.Mainloop()
 }
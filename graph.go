@@ -0,0 +1,196 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"encoding/xml"
+	"sort"
+	"strings"
+)
+
+// The element an action is described by in
+// the transition graph.
+type ActionKind string
+
+const (
+	// An operation on a resource.
+	ResourceAction ActionKind = "rsc_op"
+	// An action executed by the controller,
+	// such as fencing a node.
+	ClusterAction ActionKind = "crm_event"
+	// A pseudo action, used by the scheduler to
+	// order other actions. It is never executed.
+	PseudoAction ActionKind = "pseudo_event"
+)
+
+// The operation performed by an action.
+type ActionOperation string
+
+const (
+	ActionStart          ActionOperation = "start"
+	ActionStop           ActionOperation = "stop"
+	ActionPromote        ActionOperation = "promote"
+	ActionDemote         ActionOperation = "demote"
+	ActionMonitor        ActionOperation = "monitor"
+	ActionMigrateTo      ActionOperation = "migrate_to"
+	ActionMigrateFrom    ActionOperation = "migrate_from"
+	ActionNotify         ActionOperation = "notify"
+	ActionFence          ActionOperation = "stonith"
+	ActionShutdown       ActionOperation = "do_shutdown"
+	ActionClearFailcount ActionOperation = "clear_failcount"
+)
+
+// The actions the cluster would take to reach
+// the state the scheduler computed from a CIB,
+// as returned by Simulate.
+type TransitionGraph struct {
+	Id             int    `xml:"transition_id,attr"`
+	ClusterDelay   string `xml:"cluster-delay,attr"`
+	StonithTimeout string `xml:"stonith-timeout,attr"`
+	// All actions in the graph, ordered by id.
+	Actions []*GraphAction `xml:"-"`
+}
+
+// A single action in the transition graph.
+// Inputs are the ids of the actions that must
+// complete before this one can be executed.
+type GraphAction struct {
+	Id        int
+	Kind      ActionKind
+	Operation ActionOperation
+	// The operation key, e.g. "rsc1_start_0".
+	Key string
+	// The node the action is executed on, if any.
+	Node   string
+	NodeId string
+	// The resource operated on, for ResourceAction.
+	// For a clone instance, Instance is the id with
+	// the ":N" suffix, and Resource the id without.
+	Resource string
+	Instance string
+	// Meta attributes of the action, such as
+	// CRM_meta_interval.
+	Attributes map[string]string
+	Priority   int
+	Inputs     []int
+}
+
+// An ordering constraint between two actions:
+// Then cannot run until First has completed.
+type GraphEdge struct {
+	First int
+	Then  int
+}
+
+// Returns true if the action fences a node.
+func (action *GraphAction) IsFencing() bool {
+	return action.Kind == ClusterAction && action.Operation == ActionFence
+}
+
+// Returns the action with the given id, or nil.
+func (graph *TransitionGraph) Action(id int) *GraphAction {
+	i := sort.Search(len(graph.Actions), func(i int) bool { return graph.Actions[i].Id >= id })
+	if i < len(graph.Actions) && graph.Actions[i].Id == id {
+		return graph.Actions[i]
+	}
+	return nil
+}
+
+// Returns the actions of the graph that are
+// executed, leaving out pseudo actions.
+func (graph *TransitionGraph) Executed() []*GraphAction {
+	var result []*GraphAction
+	for _, action := range graph.Actions {
+		if action.Kind != PseudoAction {
+			result = append(result, action)
+		}
+	}
+	return result
+}
+
+// Returns all ordering constraints in the graph.
+func (graph *TransitionGraph) Edges() []GraphEdge {
+	var result []GraphEdge
+	for _, action := range graph.Actions {
+		for _, input := range action.Inputs {
+			result = append(result, GraphEdge{input, action.Id})
+		}
+	}
+	return result
+}
+
+type xmlGraphAction struct {
+	XMLName   xml.Name
+	Id        int    `xml:"id,attr"`
+	Operation string `xml:"operation,attr"`
+	Key       string `xml:"operation_key,attr"`
+	Node      string `xml:"on_node,attr"`
+	NodeId    string `xml:"on_node_uuid,attr"`
+	Primitive *struct {
+		Id     string `xml:"id,attr"`
+		LongId string `xml:"long-id,attr"`
+	} `xml:"primitive"`
+	Attributes struct {
+		Attrs []xml.Attr `xml:",any,attr"`
+	} `xml:"attributes"`
+}
+
+type xmlActionSet struct {
+	Actions []xmlGraphAction `xml:",any"`
+}
+
+type xmlGraph struct {
+	TransitionGraph
+	Synapses []struct {
+		Priority int            `xml:"priority,attr"`
+		Actions  xmlActionSet   `xml:"action_set"`
+		Inputs   []xmlActionSet `xml:"inputs>trigger"`
+	} `xml:"synapse"`
+}
+
+func parseTransitionGraph(data []byte) (*TransitionGraph, error) {
+	var v xmlGraph
+	if err := xml.Unmarshal(data, &v); err != nil {
+		return nil, &CibError{msg: "Failed to parse transition graph: " + err.Error()}
+	}
+	graph := v.TransitionGraph
+	for _, synapse := range v.Synapses {
+		var inputs []int
+		for _, trigger := range synapse.Inputs {
+			for _, input := range trigger.Actions {
+				inputs = append(inputs, input.Id)
+			}
+		}
+		for _, a := range synapse.Actions.Actions {
+			action := &GraphAction{
+				Id:        a.Id,
+				Kind:      ActionKind(a.XMLName.Local),
+				Operation: ActionOperation(a.Operation),
+				Key:       a.Key,
+				Node:      a.Node,
+				NodeId:    a.NodeId,
+				Priority:  synapse.Priority,
+				Inputs:    inputs,
+			}
+			if a.Primitive != nil {
+				action.Instance = a.Primitive.Id
+				if a.Primitive.LongId != "" {
+					action.Instance = a.Primitive.LongId
+				}
+				action.Resource = action.Instance
+				if i := strings.IndexByte(action.Resource, ':'); i >= 0 {
+					action.Resource = action.Resource[:i]
+				}
+			}
+			if len(a.Attributes.Attrs) > 0 {
+				action.Attributes = make(map[string]string)
+				for _, attr := range a.Attributes.Attrs {
+					action.Attributes[attr.Name.Local] = attr.Value
+				}
+			}
+			graph.Actions = append(graph.Actions, action)
+		}
+	}
+	sort.Slice(graph.Actions, func(i, j int) bool { return graph.Actions[i].Id < graph.Actions[j].Id })
+	return &graph, nil
+}
@@ -0,0 +1,62 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestParseTransitionGraph(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/transition-graph.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph, err := parseTransitionGraph(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if graph.Id != 0 || graph.ClusterDelay != "60s" || len(graph.Actions) != 6 {
+		t.Fatalf("Unexpected graph: %+v", graph)
+	}
+	for i := 1; i < len(graph.Actions); i++ {
+		if graph.Actions[i-1].Id >= graph.Actions[i].Id {
+			t.Error("Expected actions ordered by id")
+		}
+	}
+
+	start := graph.Action(8)
+	if start == nil || start.Kind != ResourceAction || start.Operation != ActionStart || start.Resource != "web" || start.Node != "node1" {
+		t.Errorf("Unexpected start action: %+v", start)
+	}
+	if !reflect.DeepEqual(start.Inputs, []int{7, 12}) {
+		t.Errorf("Expected start after stop and fencing, got %v", start.Inputs)
+	}
+	if start.Attributes["CRM_meta_timeout"] != "40000" {
+		t.Errorf("Unexpected attributes: %v", start.Attributes)
+	}
+
+	promote := graph.Action(15)
+	if promote.Resource != "db" || promote.Instance != "db:1" {
+		t.Errorf("Unexpected clone instance: %s %s", promote.Resource, promote.Instance)
+	}
+	fence := graph.Action(12)
+	if fence == nil || !fence.IsFencing() || fence.Node != "node2" {
+		t.Errorf("Unexpected fencing action: %+v", fence)
+	}
+	if pseudo := graph.Action(20); pseudo.Kind != PseudoAction || pseudo.Priority != 1000000 {
+		t.Errorf("Unexpected pseudo action: %+v", pseudo)
+	}
+	if graph.Action(99) != nil {
+		t.Error("Expected no action 99")
+	}
+
+	if n := len(graph.Executed()); n != 5 {
+		t.Errorf("Expected 5 executed actions, got %d", n)
+	}
+	edges := graph.Edges()
+	if len(edges) != 4 || edges[0] != (GraphEdge{7, 8}) {
+		t.Errorf("Unexpected edges: %v", edges)
+	}
+}
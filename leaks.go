@@ -0,0 +1,86 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// Documents opened while tracking is enabled,
+// by id, with the stack that opened them.
+var documentTracker struct {
+	lock    sync.Mutex
+	enabled bool
+	nextId  uint64
+	open    map[uint64]string
+}
+
+// Enables or disables tracking of CibDocument
+// instances, as a debugging aid for finding
+// documents that are never closed. While
+// enabled, the stack trace of each document
+// is recorded when it is returned, and a
+// warning including that stack is logged if a
+// document is garbage collected without
+// having been closed. Tracking is disabled by
+// default, since recording stacks is costly.
+func TrackDocuments(enable bool) {
+	documentTracker.lock.Lock()
+	defer documentTracker.lock.Unlock()
+	documentTracker.enabled = enable
+	if !enable {
+		documentTracker.open = nil
+	}
+}
+
+// Returns the stack trace of each tracked
+// document that has not yet been closed, in
+// the order they were opened.
+func OpenDocuments() []string {
+	documentTracker.lock.Lock()
+	defer documentTracker.lock.Unlock()
+	ids := make([]uint64, 0, len(documentTracker.open))
+	for id := range documentTracker.open {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	result := make([]string, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, documentTracker.open[id])
+	}
+	return result
+}
+
+// Records the caller's stack if tracking is
+// enabled, returning an id to pass to
+// untrackDocument, or 0 if not tracked.
+func trackDocument() uint64 {
+	documentTracker.lock.Lock()
+	defer documentTracker.lock.Unlock()
+	if !documentTracker.enabled {
+		return 0
+	}
+	buf := make([]byte, 4096)
+	buf = buf[:runtime.Stack(buf, false)]
+	if documentTracker.open == nil {
+		documentTracker.open = make(map[uint64]string)
+	}
+	documentTracker.nextId++
+	documentTracker.open[documentTracker.nextId] = string(buf)
+	return documentTracker.nextId
+}
+
+// Forgets a tracked document, returning the
+// stack that opened it.
+func untrackDocument(id uint64) (string, bool) {
+	if id == 0 {
+		return "", false
+	}
+	documentTracker.lock.Lock()
+	defer documentTracker.lock.Unlock()
+	stack, ok := documentTracker.open[id]
+	delete(documentTracker.open, id)
+	return stack, ok
+}
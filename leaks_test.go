@@ -0,0 +1,44 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTrackDocuments(t *testing.T) {
+	if id := trackDocument(); id != 0 {
+		t.Errorf("Expected no tracking by default, got id %d", id)
+	}
+
+	TrackDocuments(true)
+	defer TrackDocuments(false)
+	a := trackDocument()
+	b := trackDocument()
+	open := OpenDocuments()
+	if len(open) != 2 {
+		t.Fatalf("Expected 2 open documents, got %d", len(open))
+	}
+	if !strings.Contains(open[0], "TestTrackDocuments") {
+		t.Errorf("Expected stack of caller, got %s", open[0])
+	}
+
+	if _, ok := untrackDocument(a); !ok {
+		t.Error("Expected document to be tracked")
+	}
+	if _, ok := untrackDocument(a); ok {
+		t.Error("Expected document to be untracked only once")
+	}
+	if len(OpenDocuments()) != 1 {
+		t.Errorf("Expected 1 open document, got %d", len(OpenDocuments()))
+	}
+
+	TrackDocuments(false)
+	if len(OpenDocuments()) != 0 {
+		t.Error("Expected no open documents after disabling tracking")
+	}
+	if _, ok := untrackDocument(b); ok {
+		t.Error("Expected tracking to be reset")
+	}
+}
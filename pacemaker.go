@@ -4,16 +4,24 @@
 package pacemaker
 
 import (
+	"context"
 	"fmt"
+	"math"
+	"net"
+	"os"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 	"unsafe"
 
+	"github.com/ClusterLabs/go-pacemaker/cibxml"
 	log "github.com/sirupsen/logrus"
 )
 
 /*
-#cgo pkg-config: libxml-2.0 glib-2.0 libqb pacemaker pacemaker-cib
+#cgo pkg-config: libxml-2.0 glib-2.0 libffi libqb pacemaker pacemaker-cib pacemaker-pe_status libpacemaker
 #include <crm/cib.h>
 #include <crm/services.h>
 #include <crm/common/util.h>
@@ -30,8 +38,21 @@ import (
 extern int go_cib_signon(cib_t* cib, const char* name, enum cib_conn_type type);
 extern int go_cib_signoff(cib_t* cib);
 extern int go_cib_query(cib_t * cib, const char *section, xmlNode ** output_data, int call_options);
-extern unsigned int go_cib_register_notify_callbacks(cib_t * cib);
-extern void go_add_idle_scheduler(GMainLoop* loop);
+extern int go_cib_create(cib_t * cib, const char *section, xmlNode * data, int call_options);
+extern int go_cib_modify(cib_t * cib, const char *section, xmlNode * data, int call_options);
+extern int go_cib_replace(cib_t * cib, const char *section, xmlNode * data, int call_options);
+extern int go_cib_remove(cib_t * cib, const char *section, xmlNode * data, int call_options);
+struct go_cib_notify;
+extern struct go_cib_notify *go_cib_notify_new(cib_t *cib);
+extern void go_cib_notify_free(struct go_cib_notify *notify);
+extern unsigned int go_cib_register_notify_callbacks(cib_t * cib, struct go_cib_notify *notify);
+extern void go_cib_unregister_notify_callbacks(cib_t * cib, struct go_cib_notify *notify);
+extern void go_mainloop_quit(GMainLoop* loop);
+extern void go_cib_perform_call(cib_t *cib, int op, const char *section, xmlNode *data, int options, int timeout, long id);
+extern void go_mainloop_invoke(long id);
+extern xmlNode *go_cib_notify_diff(xmlNode * msg);
+extern int go_cib_apply_patch(xmlNode * msg, xmlNode * input, xmlNode ** output);
+extern xmlNode *go_cib_create_patchset(xmlNode *source, xmlNode *target);
 
 #include <libxml/parser.h>
 #include <libxml/tree.h>
@@ -66,45 +87,116 @@ static char* xmlNode2string(xmlNodePtr node) {
 */
 import "C"
 
-// Error type returned by the functions in this package.
-type CibError struct {
-	msg string
-}
-
-func (e *CibError) Error() string {
-	return e.msg
-}
-
 // Internal function used to create a CibError instance
 // from a pacemaker return code.
-func formatErrorRc(rc int) *CibError {
-	errorname := C.pcmk_errorname(C.int(rc))
-	strerror := C.pcmk_strerror(C.int(rc))
-	if errorname == nil {
-		errorname = C.CString("")
-		defer C.free(unsafe.Pointer(errorname))
+func formatErrorRc(op string, rc int) *CibError {
+	err := &CibError{Code: rc, Operation: op}
+	if errorname := C.pcmk_errorname(C.int(rc)); errorname != nil {
+		err.Name = C.GoString(errorname)
 	}
-	if strerror == nil {
-		strerror = C.CString("")
-		defer C.free(unsafe.Pointer(strerror))
+	if strerror := C.pcmk_strerror(C.int(rc)); strerror != nil {
+		err.msg = C.GoString(strerror)
 	}
-	return &CibError{fmt.Sprintf("%d: %s %s", rc, C.GoString(errorname), C.GoString(strerror))}
+	return err
 }
 
 // When connecting to Pacemaker, we have
 // to declare which type of connection to
-// use. Since the API is read-only at the
-// moment, it only really makes sense to
-// pass Query to functions that take a
-// CibConnection parameter.
+// use. Pass Query for read-only access
+// and Command to be able to use Create,
+// Modify, Replace and Delete. Command is
+// used unless another type is given.
 type CibConnection int
 
 const (
-	Query              CibConnection = C.cib_query
-	QueryXPath         CibConnection = C.cib_xpath
-	Command            CibConnection = C.cib_command
-	NoConnection       CibConnection = C.cib_no_connection
+	Query   CibConnection = C.cib_query
+	Command CibConnection = C.cib_command
+	// Create the connection without signing on,
+	// e.g. to sign on later or to only use the
+	// offline functions of the package.
+	NoConnection CibConnection = C.cib_no_connection
+	// A command connection to the local CIB using
+	// shared memory instead of a blocking socket.
 	CommandNonBlocking CibConnection = C.cib_command_nonblocking
+
+	// Deprecated: XPath queries do not need a
+	// special connection type, so this is the
+	// same as Query.
+	QueryXPath = Query
+)
+
+func (conn CibConnection) String() string {
+	switch conn {
+	case Query:
+		return "Query"
+	case Command:
+		return "Command"
+	case NoConnection:
+		return "NoConnection"
+	case CommandNonBlocking:
+		return "CommandNonBlocking"
+	}
+	return fmt.Sprintf("CibConnection(%d)", int(conn))
+}
+
+// The kind of CIB a connection is opened to.
+type CibBackend int
+
+const (
+	// The CIB of the local cluster node.
+	BackendNative CibBackend = iota
+	BackendFile
+	BackendShadow
+	BackendRemote
+)
+
+func (backend CibBackend) String() string {
+	switch backend {
+	case BackendNative:
+		return "native"
+	case BackendFile:
+		return "file"
+	case BackendShadow:
+		return "shadow"
+	case BackendRemote:
+		return "remote"
+	}
+	return fmt.Sprintf("CibBackend(%d)", int(backend))
+}
+
+// Describes a connection, as returned by
+// Cib.ConnectionInfo.
+type ConnectionInfo struct {
+	Backend CibBackend
+	// The file, the name of the shadow CIB, or
+	// host:port of a remote CIB. Empty for the
+	// native backend.
+	Target     string
+	Connection CibConnection
+	// Whether a remote connection uses TLS.
+	Encrypted bool
+	// Whether the connection is signed on.
+	Connected bool
+}
+
+// Options controlling how a call to the CIB is
+// performed. Options can be combined using a
+// bitwise or, e.g. CallSync | CallScopeLocal.
+type CibCallOption int
+
+const (
+	// Wait for the call to complete before returning.
+	CallSync CibCallOption = C.cib_sync_call
+	// Only apply the call to the local CIB instance.
+	CallScopeLocal CibCallOption = C.cib_scope_local
+	// Interpret the section argument as an XPath expression.
+	CallXPath CibCallOption = C.cib_xpath
+	// Allow an XPath expression to match multiple elements.
+	CallMultiple CibCallOption = C.cib_multiple
+	// Do not include child elements in the result.
+	CallNoChildren CibCallOption = C.cib_no_children
+	// Create the target element if it does not exist (Modify only).
+	CallCanCreate CibCallOption = C.cib_can_create
 )
 
 type CibOpenConfig struct {
@@ -116,20 +208,28 @@ type CibOpenConfig struct {
 	passwd     string
 	port       int
 	encrypted  bool
+	reconnect  *ReconnectPolicy
+	noShadow   bool
 }
 
+// Open a read-only connection.
 func ForQuery(config *CibOpenConfig) {
 	config.connection = Query
 }
 
+// Open a connection that can update the CIB.
+// This is the default.
 func ForCommand(config *CibOpenConfig) {
 	config.connection = Command
 }
 
+// Do not sign on. See NoConnection.
 func ForNoConnection(config *CibOpenConfig) {
 	config.connection = NoConnection
 }
 
+// Open a non-blocking command connection,
+// only available for the native backend.
 func ForCommandNonBlocking(config *CibOpenConfig) {
 	config.connection = CommandNonBlocking
 }
@@ -156,6 +256,20 @@ func FromRemote(server, user, passwd string, port int, encrypted bool) func(*Cib
 	}
 }
 
+// Reconnect to the CIB according to the given
+// policy if the connection is lost while there
+// are subscribers. Instead of a DestroyEvent,
+// subscribers then receive a DisconnectedEvent
+// when the connection is lost and a
+// ReconnectedEvent once it is re-established.
+// A DestroyEvent is only delivered if the
+// policy gives up.
+func WithReconnect(policy ReconnectPolicy) func(*CibOpenConfig) {
+	return func(config *CibOpenConfig) {
+		config.reconnect = &policy
+	}
+}
+
 type Element struct {
 	Type     string
 	Id       string
@@ -168,15 +282,48 @@ type CibEvent int
 const (
 	UpdateEvent  CibEvent = 0
 	DestroyEvent CibEvent = 1
+	// The connection was lost and is being
+	// re-established (see WithReconnect).
+	DisconnectedEvent CibEvent = 2
+	// The connection was re-established. The
+	// diff has no changes, but its Source is
+	// the last version seen before the
+	// connection was lost and its Target the
+	// current version. If they differ, updates
+	// were missed and the CIB should be queried
+	// again.
+	ReconnectedEvent CibEvent = 3
 )
 
 //go:generate stringer -type=CibEvent
 
 type CibEventFunc func(event CibEvent, doc *CibDocument)
 
-type subscriptionData struct {
-	Id       int
-	Callback CibEventFunc
+// Called with the patchset describing each
+// update to the CIB. The doc argument is
+// only set for subscriptions made with the
+// WithDocument option, and is only valid
+// during the call: it is closed when the
+// callback returns, so use Copy to keep it.
+type CibDiffFunc func(event CibEvent, diff *CibDiff, doc *CibDocument)
+
+type SubscribeConfig struct {
+	withDocument bool
+	buffer       int
+}
+
+// Also pass the complete, updated CIB to
+// the callback. The document is kept up to
+// date by applying each patchset to a local
+// copy, so the CIB is only queried again if
+// a patchset cannot be applied.
+func WithDocument(config *SubscribeConfig) {
+	config.withDocument = true
+}
+
+type subscriber struct {
+	callback     CibDiffFunc
+	withDocument bool
 }
 
 // Root entity representing the CIB. Can be
@@ -184,8 +331,24 @@ type subscriptionData struct {
 // method is used.
 type Cib struct {
 	cCib          *C.cib_t
-	subscribers   map[int]CibEventFunc
+	lock          sync.Mutex
+	subscribers   map[int]*subscriber
 	notifications uint
+	nextId        int
+	current       *C.xmlNode
+	config        CibOpenConfig
+	info          ConnectionInfo
+	// The notify callback, created for the first
+	// subscriber and kept until the connection is
+	// closed, since it may be running when the
+	// last subscriber is removed.
+	notify *C.struct_go_cib_notify
+	// The last version seen, for reporting the
+	// gap after reconnecting.
+	version *CibVersion
+	// Closed by Close to stop reconnecting, only
+	// set for connections opened WithReconnect.
+	stop chan struct{}
 }
 
 type CibVersion struct {
@@ -194,8 +357,38 @@ type CibVersion struct {
 	NumUpdates int32
 }
 
+// An XML document returned from the CIB,
+// which must be closed to release it. Close
+// may be called more than once. A document
+// that is garbage collected without being
+// closed is released by a finalizer, but
+// since the collector knows nothing about
+// the memory held by the document, that may
+// not happen soon enough: see TrackDocuments
+// for finding such documents.
 type CibDocument struct {
-	xml *C.xmlNode
+	lock sync.Mutex
+	xml  *C.xmlNode
+	// Set for documents owned by the Cib, which
+	// are only lent to subscriber callbacks.
+	borrowed bool
+	// Id in the document tracker, if any.
+	tracked uint64
+}
+
+// Wraps a document owned by the caller.
+func newDocument(xml *C.xmlNode) *CibDocument {
+	doc := &CibDocument{xml: xml, tracked: trackDocument()}
+	runtime.SetFinalizer(doc, finalizeDocument)
+	return doc
+}
+
+func finalizeDocument(doc *CibDocument) {
+	if stack, ok := untrackDocument(doc.tracked); ok {
+		log.Warnf("CibDocument was not closed, opened at:\n%s", stack)
+	}
+	doc.tracked = 0
+	doc.Close()
 }
 
 func (ver *CibVersion) String() string {
@@ -203,13 +396,14 @@ func (ver *CibVersion) String() string {
 }
 
 func OpenCib(options ...func(*CibOpenConfig)) (*Cib, error) {
-	var cib Cib
+	cib := Cib{}
 	config := CibOpenConfig{}
 	for _, opt := range options {
 		opt(&config)
 	}
-	if config.connection != Query && config.connection != Command {
-		config.connection = Query
+	info, err := config.connectionInfo()
+	if err != nil {
+		return nil, err
 	}
 	if config.file != "" {
 		s := C.CString(config.file)
@@ -231,38 +425,223 @@ func OpenCib(options ...func(*CibOpenConfig)) (*Cib, error) {
 			e = 1
 		}
 		cib.cCib = C.cib_remote_new(s, u, p, (C.int)(config.port), (C.gboolean)(e))
+		// The connection keeps its own copy, which
+		// is used to sign on again when reconnecting.
+		config.passwd = ""
+	} else if config.noShadow {
+		cib.cCib = C.cib_new_no_shadow()
 	} else {
 		cib.cCib = C.cib_new()
 	}
 
-	rc := C.go_cib_signon(cib.cCib, C.crm_system_name, (uint32)(config.connection))
-	if rc != C.pcmk_ok {
-		return nil, formatErrorRc((int)(rc))
+	if config.connection != NoConnection {
+		rc := C.go_cib_signon(cib.cCib, C.crm_system_name, (uint32)(config.connection))
+		if rc != C.pcmk_ok {
+			C.cib_delete(cib.cCib)
+			return nil, formatErrorRc("signon", (int)(rc))
+		}
 	}
 
+	cib.config = config
+	cib.info = info
+	if config.reconnect != nil {
+		cib.stop = make(chan struct{})
+	}
 	return &cib, nil
 }
 
+// Ignore CIB_shadow when choosing the backend,
+// to reach the live CIB even from a shell where
+// a shadow CIB is active.
+func withoutShadow(config *CibOpenConfig) {
+	config.noShadow = true
+}
+
+// Describes the connection that OpenCib will
+// make, or returns an error if the options
+// cannot be honoured. Without a From* option,
+// the backend is chosen by pacemaker from the
+// CIB_shadow, CIB_file and CIB_port environment
+// variables, in the same way as for the command
+// line tools.
+func (config *CibOpenConfig) connectionInfo() (ConnectionInfo, error) {
+	info := ConnectionInfo{Connection: config.connection}
+	sources := 0
+	if config.file != "" {
+		info.Backend, info.Target = BackendFile, config.file
+		sources++
+	}
+	if config.shadow != "" {
+		info.Backend, info.Target = BackendShadow, config.shadow
+		sources++
+	}
+	if config.server != "" {
+		info.Backend = BackendRemote
+		info.Target = net.JoinHostPort(config.server, strconv.Itoa(config.port))
+		info.Encrypted = config.encrypted
+		sources++
+	}
+	if sources > 1 {
+		return info, &CibError{Operation: "open", msg: "Only one of FromFile, FromShadow and FromRemote can be used"}
+	}
+	if sources == 0 {
+		if shadow := os.Getenv("CIB_shadow"); shadow != "" && !config.noShadow {
+			info.Backend, info.Target = BackendShadow, shadow
+		} else if file := os.Getenv("CIB_file"); file != "" {
+			info.Backend, info.Target = BackendFile, file
+		} else if port := os.Getenv("CIB_port"); port != "" {
+			server := os.Getenv("CIB_server")
+			if server == "" {
+				server = "localhost"
+			}
+			encrypted, ok := os.LookupEnv("CIB_encrypted")
+			info.Backend = BackendRemote
+			info.Target = net.JoinHostPort(server, port)
+			info.Encrypted = !ok || IsTrue(encrypted)
+		}
+	}
+
+	switch config.connection {
+	case Query, Command, NoConnection:
+	case CommandNonBlocking:
+		if info.Backend != BackendNative {
+			return info, &CibError{Operation: "open", msg: "CommandNonBlocking is not supported for the " + info.Backend.String() + " backend"}
+		}
+	default:
+		return info, &CibError{Operation: "open", msg: "Invalid connection type " + config.connection.String()}
+	}
+	return info, nil
+}
+
+// Returns the backend, target and connection
+// type in use.
+func (cib *Cib) ConnectionInfo() ConnectionInfo {
+	cib.lock.Lock()
+	defer cib.lock.Unlock()
+	info := cib.info
+	info.Connected = cib.cCib != nil && cib.cCib.state != C.cib_disconnected
+	return info
+}
+
+// Returns a new CIB without any configuration,
+// using the latest schema.
+func emptyCib() (string, error) {
+	root := C.createEmptyCib(0)
+	if root == nil {
+		return "", &CibError{msg: "Failed to create empty CIB"}
+	}
+	defer C.free_xml(root)
+	return xmlString(root)
+}
+
+// Computes the patchset turning the source CIB
+// into the target, or nil if they are the same.
+func createPatchset(source, target string) (*CibDiff, error) {
+	src, err := parseXml(cibQuery, source)
+	if err != nil || src == nil {
+		return nil, &CibError{msg: "Failed to parse source CIB"}
+	}
+	defer C.free_xml(src)
+	tgt, err := parseXml(cibQuery, target)
+	if err != nil || tgt == nil {
+		return nil, &CibError{msg: "Failed to parse target CIB"}
+	}
+	defer C.free_xml(tgt)
+	patchset := C.go_cib_create_patchset(src, tgt)
+	if patchset == nil {
+		return nil, nil
+	}
+	defer C.free_xml(patchset)
+	data, err := xmlString(patchset)
+	if err != nil {
+		return nil, err
+	}
+	return parseCibDiff(data)
+}
+
+func xmlString(node *C.xmlNode) (string, error) {
+	buffer := C.xmlNode2string(node)
+	if buffer == nil {
+		return "", &CibError{msg: "Failed to convert XML"}
+	}
+	defer C.free(unsafe.Pointer(buffer))
+	return C.GoString(buffer), nil
+}
+
 func GetShadowFile(name string) string {
 	s := C.CString(name)
 	defer C.free(unsafe.Pointer(s))
 	return C.GoString(C.get_shadow_file(s))
 }
 
+// Closes the connection. Closing a connection
+// that is already closed has no effect.
+//
+// The connection is torn down from the main
+// loop, so that it is not freed while its
+// callbacks are being dispatched.
 func (cib *Cib) Close() error {
-	rc := C.go_cib_signoff(cib.cCib)
-	if rc != C.pcmk_ok {
-		return formatErrorRc((int)(rc))
+	var err error
+	done := make(chan struct{})
+	invokeMainloop(func() {
+		defer close(done)
+		err = cib.close()
+	})
+	<-done
+	return err
+}
+
+func (cib *Cib) close() error {
+	cib.lock.Lock()
+	if cib.cCib == nil {
+		cib.lock.Unlock()
+		return nil
+	}
+	if cib.stop != nil {
+		close(cib.stop)
+		cib.stop = nil
 	}
-	C.cib_delete(cib.cCib)
+	cib.unregisterNotify()
+	cib.cancelCalls()
+	cCib := cib.cCib
 	cib.cCib = nil
+	notify := cib.notify
+	cib.notify = nil
+	cib.lock.Unlock()
+	rc := C.go_cib_signoff(cCib)
+	C.cib_delete(cCib)
+	if notify != nil {
+		C.go_cib_notify_free(notify)
+	}
+	if rc != C.pcmk_ok {
+		return formatErrorRc("signoff", (int)(rc))
+	}
 	return nil
 }
 
+// ParseDocument parses a CIB in XML format, for
+// example to check a configuration with Validate
+// before pushing it to the cluster. The caller
+// must close the returned document.
+func ParseDocument(xml string) (*CibDocument, error) {
+	s := C.CString(xml)
+	defer C.free(unsafe.Pointer(s))
+	data := C.string2xml(s)
+	if data == nil {
+		return nil, &CibError{msg: "Failed to parse XML"}
+	}
+	return newDocument(data), nil
+}
+
 func (doc *CibDocument) Version() *CibVersion {
 	var admin_epoch C.int
 	var epoch C.int
 	var num_updates C.int
+	doc.lock.Lock()
+	defer doc.lock.Unlock()
+	if doc.xml == nil {
+		return nil
+	}
 	ok := C.cib_version_details(doc.xml, (*C.int)(unsafe.Pointer(&admin_epoch)), (*C.int)(unsafe.Pointer(&epoch)), (*C.int)(unsafe.Pointer(&num_updates)))
 	if ok == 1 {
 		return &CibVersion{(int32)(admin_epoch), (int32)(epoch), (int32)(num_updates)}
@@ -271,6 +650,11 @@ func (doc *CibDocument) Version() *CibVersion {
 }
 
 func (doc *CibDocument) ToString() string {
+	doc.lock.Lock()
+	defer doc.lock.Unlock()
+	if doc.xml == nil {
+		return ""
+	}
 	buffer := C.xmlNode2string(doc.xml)
 	if buffer == nil {
 		log.Errorf("Error: Failed to convert XML")
@@ -280,16 +664,106 @@ func (doc *CibDocument) ToString() string {
 	return C.GoString(buffer)
 }
 
+// Decode parses the configuration section of the
+// document into a typed Configuration tree. The
+// document must either be a complete CIB or the
+// configuration element itself.
+func (doc *CibDocument) Decode() (*Configuration, error) {
+	return decodeConfiguration([]byte(doc.ToString()))
+}
+
+// DecodeStatus parses the status section of the
+// document into a typed Status tree. The document
+// must either be a complete CIB or the status
+// element itself.
+func (doc *CibDocument) DecodeStatus() (*Status, error) {
+	return decodeStatus([]byte(doc.ToString()))
+}
+
+// ResourceStates computes the state of each
+// resource on each node from a complete CIB,
+// see ComputeResourceStates.
+func (doc *CibDocument) ResourceStates() ([]*ResourceStatus, error) {
+	data := []byte(doc.ToString())
+	conf, err := decodeConfiguration(data)
+	if err != nil {
+		return nil, err
+	}
+	status, err := decodeStatus(data)
+	if err != nil {
+		return nil, err
+	}
+	return ComputeResourceStates(conf, status), nil
+}
+
+// MarshalJSON encodes a complete CIB as
+// CibContents. Documents returned by XPath
+// queries for the configuration or status
+// section are encoded as Configuration or
+// Status respectively.
+func (doc *CibDocument) MarshalJSON() ([]byte, error) {
+	return encodeJSON([]byte(doc.ToString()))
+}
+
+// Element converts the document to the pure Go
+// model in the cibxml package, which remains
+// valid after the document is closed.
+func (doc *CibDocument) Element() (*cibxml.Element, error) {
+	data := doc.ToString()
+	if data == "" {
+		return nil, &CibError{msg: "Document is closed"}
+	}
+	return cibxml.ParseString(data)
+}
+
+// Copy returns an independent copy of the
+// document, which the caller must close, or
+// nil if the document is closed.
+func (doc *CibDocument) Copy() *CibDocument {
+	doc.lock.Lock()
+	defer doc.lock.Unlock()
+	if doc.xml == nil {
+		return nil
+	}
+	return newDocument(C.copy_xml(doc.xml))
+}
+
+// Releases the document. A document passed
+// to a subscriber callback remains owned by
+// the Cib: closing it only makes it unusable,
+// as it is once the callback returns.
 func (doc *CibDocument) Close() {
-	C.free_xml(doc.xml)
+	doc.lock.Lock()
+	defer doc.lock.Unlock()
+	if doc.xml == nil {
+		return
+	}
+	if !doc.borrowed {
+		C.free_xml(doc.xml)
+	}
+	doc.xml = nil
+	untrackDocument(doc.tracked)
+	doc.tracked = 0
+	runtime.SetFinalizer(doc, nil)
 }
 
 func (cib *Cib) queryImpl(xpath string, nochildren bool) (*C.xmlNode, error) {
+	cib.lock.Lock()
+	defer cib.lock.Unlock()
+	return cib.queryLocked(xpath, nochildren)
+}
+
+// Must be called with cib.lock held.
+func (cib *Cib) queryLocked(xpath string, nochildren bool) (*C.xmlNode, error) {
 	var root *C.xmlNode
 	var rc C.int
 
 	var opts C.int
 
+	if cib.cCib == nil {
+		return nil, errClosed("query")
+	}
+
 	opts = C.cib_sync_call + C.cib_scope_local
 
 	if xpath != "" {
@@ -308,17 +782,24 @@ func (cib *Cib) queryImpl(xpath string, nochildren bool) (*C.xmlNode, error) {
 		rc = C.go_cib_query(cib.cCib, nil, (**C.xmlNode)(unsafe.Pointer(&root)), opts)
 	}
 	if rc != C.pcmk_ok {
-		return nil, formatErrorRc((int)(rc))
+		return nil, formatErrorRc("query", (int)(rc))
 	}
 	return root, nil
 }
 
 func (cib *Cib) Version() (*CibVersion, error) {
+	cib.lock.Lock()
+	defer cib.lock.Unlock()
+	return cib.queryVersion()
+}
+
+// Must be called with cib.lock held.
+func (cib *Cib) queryVersion() (*CibVersion, error) {
 	var admin_epoch C.int
 	var epoch C.int
 	var num_updates C.int
 
-	root, err := cib.queryImpl("/cib", true)
+	root, err := cib.queryLocked("/cib", true)
 	if err != nil {
 		return nil, err
 	}
@@ -327,7 +808,7 @@ func (cib *Cib) Version() (*CibVersion, error) {
 	if ok == 1 {
 		return &CibVersion{(int32)(admin_epoch), (int32)(epoch), (int32)(num_updates)}, nil
 	}
-	return nil, &CibError{"Failed to get CIB version details"}
+	return nil, &CibError{msg: "Failed to get CIB version details"}
 }
 
 func (cib *Cib) Query() (*CibDocument, error) {
@@ -337,7 +818,7 @@ func (cib *Cib) Query() (*CibDocument, error) {
 		return nil, err
 	}
 
-	return &CibDocument{root}, nil
+	return newDocument(root), nil
 }
 
 func (cib *Cib) QueryNoChildren() (*CibDocument, error) {
@@ -346,7 +827,7 @@ func (cib *Cib) QueryNoChildren() (*CibDocument, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &CibDocument{root}, nil
+	return newDocument(root), nil
 }
 
 func (cib *Cib) QueryXPath(xpath string) (*CibDocument, error) {
@@ -355,7 +836,7 @@ func (cib *Cib) QueryXPath(xpath string) (*CibDocument, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &CibDocument{root}, nil
+	return newDocument(root), nil
 }
 
 func (cib *Cib) QueryXPathNoChildren(xpath string) (*CibDocument, error) {
@@ -364,7 +845,415 @@ func (cib *Cib) QueryXPathNoChildren(xpath string) (*CibDocument, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &CibDocument{root}, nil
+	return newDocument(root), nil
+}
+
+type cibOp int
+
+const (
+	cibCreate cibOp = iota
+	cibModify
+	cibReplace
+	cibDelete
+	cibQuery
+)
+
+func (op cibOp) String() string {
+	return [...]string{"create", "modify", "replace", "delete", "query"}[op]
+}
+
+func callOptions(options []CibCallOption) C.int {
+	if len(options) == 0 {
+		return C.cib_sync_call
+	}
+	var opts C.int
+	for _, opt := range options {
+		opts |= C.int(opt)
+	}
+	return opts
+}
+
+// Returns nil for an empty string.
+func parseXml(op cibOp, xml string) (*C.xmlNode, error) {
+	if xml == "" {
+		return nil, nil
+	}
+	s := C.CString(xml)
+	defer C.free(unsafe.Pointer(s))
+	data := C.string2xml(s)
+	if data == nil {
+		return nil, &CibError{Operation: op.String(), msg: "Failed to parse XML"}
+	}
+	return data, nil
+}
+
+func (cib *Cib) writeImpl(op cibOp, section string, xml string, options []CibCallOption) error {
+	var sect *C.char
+	var rc C.int
+
+	cib.lock.Lock()
+	defer cib.lock.Unlock()
+	if cib.cCib == nil {
+		return errClosed(op.String())
+	}
+
+	data, err := parseXml(op, xml)
+	if err != nil {
+		return err
+	}
+	if data != nil {
+		defer C.free_xml(data)
+	}
+
+	if section != "" {
+		sect = C.CString(section)
+		defer C.free(unsafe.Pointer(sect))
+	}
+
+	opts := callOptions(options)
+	switch op {
+	case cibCreate:
+		rc = C.go_cib_create(cib.cCib, sect, data, opts)
+	case cibModify:
+		rc = C.go_cib_modify(cib.cCib, sect, data, opts)
+	case cibReplace:
+		rc = C.go_cib_replace(cib.cCib, sect, data, opts)
+	case cibDelete:
+		rc = C.go_cib_remove(cib.cCib, sect, data, opts)
+	}
+	if rc < C.pcmk_ok {
+		return formatErrorRc(op.String(), (int)(rc))
+	}
+	return nil
+}
+
+// Create adds the XML fragment to the given CIB section,
+// for example "resources" or "constraints".
+//
+// If no options are given, the call is synchronous. When
+// options are given without CallSync, the call is only
+// queued and errors from applying it are not reported.
+func (cib *Cib) Create(section string, xml string, options ...CibCallOption) error {
+	return cib.writeImpl(cibCreate, section, xml, options)
+}
+
+// Modify merges the XML fragment into the matching
+// element of the given CIB section. Pass CallCanCreate
+// to create the element if it does not exist yet.
+func (cib *Cib) Modify(section string, xml string, options ...CibCallOption) error {
+	return cib.writeImpl(cibModify, section, xml, options)
+}
+
+// Replace replaces the given CIB section (or the whole
+// CIB if section is empty) with the XML fragment.
+func (cib *Cib) Replace(section string, xml string, options ...CibCallOption) error {
+	return cib.writeImpl(cibReplace, section, xml, options)
+}
+
+// Delete removes the element described by the XML fragment
+// from the given CIB section. When CallXPath is passed,
+// section is an XPath expression selecting the elements to
+// remove and xml may be empty.
+func (cib *Cib) Delete(section string, xml string, options ...CibCallOption) error {
+	return cib.writeImpl(cibDelete, section, xml, options)
+}
+
+func (cib *Cib) queryConfiguration() (*Configuration, error) {
+	doc, err := cib.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer doc.Close()
+	return doc.Decode()
+}
+
+// ClusterProperties returns the cluster options
+// in effect, see Configuration.ClusterProperties.
+func (cib *Cib) ClusterProperties() (*ClusterProperties, error) {
+	conf, err := cib.queryConfiguration()
+	if err != nil {
+		return nil, err
+	}
+	return conf.ClusterProperties()
+}
+
+// SetProperty sets a cluster option in the
+// cib-bootstrap-options property set, creating
+// the set if it does not exist. Unknown options,
+// options maintained by the cluster and values
+// of the wrong type are rejected.
+func (cib *Cib) SetProperty(name string, value string) error {
+	if err := checkClusterProperty(name, value); err != nil {
+		return err
+	}
+	conf, err := cib.queryConfiguration()
+	if err != nil {
+		return err
+	}
+	nv := &Nvpair{Id: bootstrapOptions + "-" + name, Name: name, Value: value}
+	for _, set := range conf.CrmConfig {
+		if set.Id != bootstrapOptions {
+			continue
+		}
+		for _, existing := range set.Nvpairs {
+			if existing.Name == name && existing.Id != "" {
+				nv.Id = existing.Id
+			}
+		}
+	}
+	xml, err := marshalElement("cluster_property_set", &AttributeSet{Id: bootstrapOptions, Nvpairs: []*Nvpair{nv}})
+	if err != nil {
+		return err
+	}
+	return cib.Modify("crm_config", xml, CallCanCreate, CallSync)
+}
+
+// UnsetProperty removes a cluster option from
+// every property set, so that its default
+// applies again. Removing a property that is not
+// set is not an error.
+func (cib *Cib) UnsetProperty(name string) error {
+	conf, err := cib.queryConfiguration()
+	if err != nil {
+		return err
+	}
+	for _, set := range conf.CrmConfig {
+		for _, nv := range set.Nvpairs {
+			if nv.Name != name || nv.Id == "" {
+				continue
+			}
+			xml, err := marshalElement("nvpair", &Nvpair{Id: nv.Id, Name: nv.Name, Value: nv.Value})
+			if err != nil {
+				return err
+			}
+			if err := cib.Delete("crm_config", xml, CallSync); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// QueryContext is like Query, but gives up when the
+// context is done. The query is issued as an
+// asynchronous call, and the reply is delivered by
+// the main loop, so Mainloop must be running. If the
+// deadline passes first, the error matches both
+// ErrTimeout and context.DeadlineExceeded.
+func (cib *Cib) QueryContext(ctx context.Context) (*CibDocument, error) {
+	return cib.queryContext(ctx, "")
+}
+
+// QueryXPathContext is like QueryXPath, but gives up
+// when the context is done. See QueryContext.
+func (cib *Cib) QueryXPathContext(ctx context.Context, xpath string) (*CibDocument, error) {
+	return cib.queryContext(ctx, xpath)
+}
+
+func (cib *Cib) queryContext(ctx context.Context, xpath string) (*CibDocument, error) {
+	opts := C.int(C.cib_scope_local)
+	if xpath != "" {
+		opts |= C.cib_xpath
+	}
+	root, err := cib.callContext(ctx, cibQuery, xpath, "", opts)
+	if err != nil {
+		return nil, err
+	}
+	return newDocument(root), nil
+}
+
+// CreateContext is like Create, but always waits for
+// the result of the call, giving up when the context
+// is done. CallSync is ignored. See QueryContext.
+func (cib *Cib) CreateContext(ctx context.Context, section string, xml string, options ...CibCallOption) error {
+	return cib.writeContext(ctx, cibCreate, section, xml, options)
+}
+
+// ModifyContext is like Modify, but gives up when
+// the context is done. See CreateContext.
+func (cib *Cib) ModifyContext(ctx context.Context, section string, xml string, options ...CibCallOption) error {
+	return cib.writeContext(ctx, cibModify, section, xml, options)
+}
+
+// ReplaceContext is like Replace, but gives up when
+// the context is done. See CreateContext.
+func (cib *Cib) ReplaceContext(ctx context.Context, section string, xml string, options ...CibCallOption) error {
+	return cib.writeContext(ctx, cibReplace, section, xml, options)
+}
+
+// DeleteContext is like Delete, but gives up when
+// the context is done. See CreateContext.
+func (cib *Cib) DeleteContext(ctx context.Context, section string, xml string, options ...CibCallOption) error {
+	return cib.writeContext(ctx, cibDelete, section, xml, options)
+}
+
+func (cib *Cib) writeContext(ctx context.Context, op cibOp, section string, xml string, options []CibCallOption) error {
+	output, err := cib.callContext(ctx, op, section, xml, callOptions(options))
+	if output != nil {
+		C.free_xml(output)
+	}
+	return err
+}
+
+// Timeout in seconds after which pacemaker drops
+// the callback of an asynchronous call whose
+// context has no deadline, so that the callback
+// of a cancelled call is not kept forever.
+const defaultCallTimeout = 120
+
+type callResult struct {
+	rc     int
+	output *C.xmlNode
+	err    error
+}
+
+type pendingCall struct {
+	cib    *Cib
+	op     cibOp
+	result chan callResult
+}
+
+// Asynchronous calls waiting for their result,
+// by the id passed to go_cib_perform_call.
+var pendingCalls = struct {
+	sync.Mutex
+	nextId C.long
+	calls  map[C.long]*pendingCall
+}{calls: make(map[C.long]*pendingCall)}
+
+// Removes the call from the pending calls, and
+// returns it unless it was already removed.
+func takePendingCall(id C.long) *pendingCall {
+	pendingCalls.Lock()
+	defer pendingCalls.Unlock()
+	call, ok := pendingCalls.calls[id]
+	if !ok {
+		return nil
+	}
+	delete(pendingCalls.calls, id)
+	return call
+}
+
+// Issues an asynchronous call and waits for its
+// result, or for the context to be done. The call
+// is issued from the main loop, and fails if the
+// connection is closed before then.
+func (cib *Cib) callContext(ctx context.Context, op cibOp, section string, xml string, opts C.int) (*C.xmlNode, error) {
+	cib.lock.Lock()
+	closed := cib.cCib == nil
+	cib.lock.Unlock()
+	if closed {
+		return nil, errClosed(op.String())
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, contextError(op.String(), err)
+	}
+	// Lets pacemaker drop the callback if no reply
+	// ever arrives. The deadline itself is enforced
+	// by the select below.
+	timeout := defaultCallTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, contextError(op.String(), context.DeadlineExceeded)
+		}
+		// Rounded up, so that it is at least 1: a
+		// timeout of 0 never expires.
+		timeout = int(remaining/time.Second) + 1
+		if remaining/time.Second >= math.MaxInt32 {
+			timeout = math.MaxInt32
+		}
+	}
+
+	call := &pendingCall{cib: cib, op: op, result: make(chan callResult, 1)}
+	pendingCalls.Lock()
+	pendingCalls.nextId++
+	id := pendingCalls.nextId
+	pendingCalls.calls[id] = call
+	pendingCalls.Unlock()
+
+	invokeMainloop(func() {
+		cib.issueCall(id, op, section, xml, opts&^C.cib_sync_call, timeout)
+	})
+
+	var res callResult
+	select {
+	case res = <-call.result:
+	case <-ctx.Done():
+		if takePendingCall(id) != nil {
+			return nil, contextError(op.String(), ctx.Err())
+		}
+		// The result is already on its way
+		res = <-call.result
+	}
+	if res.err != nil {
+		return nil, res.err
+	}
+	if res.rc < C.pcmk_ok {
+		if res.output != nil {
+			C.free_xml(res.output)
+		}
+		return nil, formatErrorRc(op.String(), res.rc)
+	}
+	return res.output, nil
+}
+
+// Issues a call queued by callContext, unless it
+// was cancelled in the meantime. Runs from the
+// main loop.
+func (cib *Cib) issueCall(id C.long, op cibOp, section string, xml string, opts C.int, timeout int) {
+	pendingCalls.Lock()
+	_, ok := pendingCalls.calls[id]
+	pendingCalls.Unlock()
+	if !ok {
+		return
+	}
+	cib.lock.Lock()
+	defer cib.lock.Unlock()
+	if cib.cCib == nil {
+		completeCall(id, callResult{err: errClosed(op.String())})
+		return
+	}
+	data, err := parseXml(op, xml)
+	if err != nil {
+		completeCall(id, callResult{err: err})
+		return
+	}
+	if data != nil {
+		defer C.free_xml(data)
+	}
+	var sect *C.char
+	if section != "" {
+		sect = C.CString(section)
+		defer C.free(unsafe.Pointer(sect))
+	}
+	C.go_cib_perform_call(cib.cCib, C.int(op), sect, data, opts, C.int(timeout), id)
+}
+
+// Delivers the result to the caller waiting for
+// the call, if it is still waiting. Returns false
+// otherwise.
+func completeCall(id C.long, res callResult) bool {
+	call := takePendingCall(id)
+	if call == nil {
+		return false
+	}
+	call.result <- res
+	return true
+}
+
+// Fails the calls waiting for a reply on the
+// connection, since cib_delete drops their
+// callbacks. Must be called with cib.lock held.
+func (cib *Cib) cancelCalls() {
+	pendingCalls.Lock()
+	defer pendingCalls.Unlock()
+	for id, call := range pendingCalls.calls {
+		if call.cib == cib {
+			delete(pendingCalls.calls, id)
+			call.result <- callResult{err: errClosed(call.op.String())}
+		}
+	}
 }
 
 func init() {
@@ -378,46 +1267,454 @@ func IsTrue(bstr string) bool {
 	return sl == "true" || sl == "on" || sl == "yes" || sl == "y" || sl == "1"
 }
 
-var the_cib *Cib
+// Connections with subscribers, by the
+// cib_t passed to the C callbacks.
+var notifyConnections = struct {
+	sync.Mutex
+	cibs map[*C.cib_t]*Cib
+}{cibs: make(map[*C.cib_t]*Cib)}
+
+// Handle returned by Subscribe, used to
+// remove the callback again.
+type Subscription struct {
+	cib *Cib
+	id  int
+}
 
-func (cib *Cib) Subscribers() map[int]CibEventFunc {
-	return cib.subscribers
+func (sub *Subscription) Id() int {
+	return sub.id
 }
 
-func (cib *Cib) Subscribe(callback CibEventFunc) (uint, error) {
-	the_cib = cib
+// Unsubscribe removes the callback. When the
+// last subscriber is removed, the connection
+// stops listening for notifications. This is
+// done from the main loop, so Unsubscribe may
+// be called from any goroutine.
+func (sub *Subscription) Unsubscribe() {
+	cib := sub.cib
+	cib.lock.Lock()
+	delete(cib.subscribers, sub.id)
+	last := len(cib.subscribers) == 0
+	cib.lock.Unlock()
+	if !last {
+		return
+	}
+	invokeMainloop(func() {
+		cib.lock.Lock()
+		defer cib.lock.Unlock()
+		// Unless there are new subscribers
+		if len(cib.subscribers) == 0 {
+			cib.unregisterNotify()
+		}
+	})
+}
+
+func (cib *Cib) Subscribers() map[int]CibDiffFunc {
+	cib.lock.Lock()
+	defer cib.lock.Unlock()
+	result := make(map[int]CibDiffFunc, len(cib.subscribers))
+	for id, sub := range cib.subscribers {
+		result[id] = sub.callback
+	}
+	return result
+}
+
+// Returns the flags reported when registering
+// for notifications, indicating which kinds
+// of events the connection can deliver.
+func (cib *Cib) Notifications() uint {
+	cib.lock.Lock()
+	defer cib.lock.Unlock()
+	return cib.notifications
+}
+
+// Subscribe registers a callback to be called
+// with the complete CIB on each update and when
+// the connection is lost. Callbacks are called
+// from the thread running Mainloop.
+func (cib *Cib) Subscribe(callback CibEventFunc) (*Subscription, error) {
+	return cib.SubscribeDiff(func(event CibEvent, diff *CibDiff, doc *CibDocument) {
+		callback(event, doc)
+	}, WithDocument)
+}
+
+// SubscribeDiff registers a callback to be
+// called with the patchset describing each
+// update to the CIB, and when the connection
+// is lost. Callbacks are called from the
+// thread running Mainloop. WithBuffer only
+// applies to Events, and is rejected here.
+func (cib *Cib) SubscribeDiff(callback CibDiffFunc, options ...func(*SubscribeConfig)) (*Subscription, error) {
+	config := SubscribeConfig{}
+	for _, opt := range options {
+		opt(&config)
+	}
+	if config.buffer != 0 {
+		return nil, &CibError{Operation: "subscribe", msg: "WithBuffer is only supported by Events"}
+	}
+	return cib.subscribe(callback, config.withDocument)
+}
+
+func (cib *Cib) subscribe(callback CibDiffFunc, withDocument bool) (*Subscription, error) {
+	cib.lock.Lock()
+	defer cib.lock.Unlock()
 	if cib.subscribers == nil {
-		cib.subscribers = make(map[int]CibEventFunc)
-		flags := C.go_cib_register_notify_callbacks(cib.cCib)
-		cib.notifications = uint(flags)
+		if err := cib.registerNotify(); err != nil {
+			return nil, err
+		}
+		cib.subscribers = make(map[int]*subscriber)
+		if cib.stop != nil {
+			cib.version, _ = cib.queryVersion()
+		}
+	}
+	if withDocument {
+		// The local copy may be stale if no
+		// subscriber needed it until now.
+		cib.dropDocument()
+	}
+	cib.nextId++
+	id := cib.nextId
+	cib.subscribers[id] = &subscriber{callback, withDocument}
+	return &Subscription{cib, id}, nil
+}
+
+// Must be called with cib.lock held.
+func (cib *Cib) registerNotify() error {
+	if cib.cCib == nil {
+		return errClosed("subscribe")
+	}
+	if cib.notify == nil {
+		cib.notify = C.go_cib_notify_new(cib.cCib)
+		if cib.notify == nil {
+			return &CibError{Operation: "subscribe", msg: "Failed to create notify callback"}
+		}
+	}
+	notifyConnections.Lock()
+	notifyConnections.cibs[cib.cCib] = cib
+	notifyConnections.Unlock()
+	cib.notifications = uint(C.go_cib_register_notify_callbacks(cib.cCib, cib.notify))
+	return nil
+}
+
+// Must be called with cib.lock held.
+func (cib *Cib) unregisterNotify() {
+	if cib.subscribers == nil {
+		return
+	}
+	if cib.cCib != nil {
+		C.go_cib_unregister_notify_callbacks(cib.cCib, cib.notify)
+		notifyConnections.Lock()
+		delete(notifyConnections.cibs, cib.cCib)
+		notifyConnections.Unlock()
+	}
+	cib.subscribers = nil
+	cib.notifications = 0
+	cib.dropDocument()
+}
+
+// Must be called with cib.lock held.
+func (cib *Cib) dropDocument() {
+	if cib.current != nil {
+		C.free_xml(cib.current)
+		cib.current = nil
+	}
+}
+
+// Applies the patchset in the notification
+// to the local copy of the CIB, or queries
+// the complete CIB if that fails.
+func (cib *Cib) updateDocument(msg *C.xmlNode) (*CibDocument, error) {
+	cib.lock.Lock()
+	defer cib.lock.Unlock()
+	if cib.current != nil {
+		var output *C.xmlNode
+		rc := C.go_cib_apply_patch(msg, cib.current, (**C.xmlNode)(unsafe.Pointer(&output)))
+		if rc == C.pcmk_ok && output != nil {
+			C.free_xml(cib.current)
+			cib.current = output
+			return &CibDocument{xml: cib.current, borrowed: true}, nil
+		}
+		if output != nil {
+			C.free_xml(output)
+		}
+		cib.dropDocument()
 	}
-	id := len(cib.subscribers)
-	cib.subscribers[id] = callback
-	return cib.notifications, nil
+	root, err := cib.queryLocked("", false)
+	if err != nil {
+		return nil, err
+	}
+	cib.current = root
+	return &CibDocument{xml: cib.current, borrowed: true}, nil
+}
+
+func lookupConnection(cCib *C.cib_t) *Cib {
+	notifyConnections.Lock()
+	defer notifyConnections.Unlock()
+	return notifyConnections.cibs[cCib]
+}
+
+// Returns a snapshot of the subscribers, so
+// that callbacks may call Unsubscribe.
+func (cib *Cib) callbacks() []*subscriber {
+	cib.lock.Lock()
+	defer cib.lock.Unlock()
+	result := make([]*subscriber, 0, len(cib.subscribers))
+	for _, sub := range cib.subscribers {
+		result = append(result, sub)
+	}
+	return result
 }
 
 //export diffNotifyCallback
-func diffNotifyCallback(current_cib *C.xmlNode) {
-	for _, callback := range the_cib.subscribers {
-		callback(UpdateEvent, &CibDocument{current_cib})
+func diffNotifyCallback(cCib *C.cib_t, msg *C.xmlNode) {
+	cib := lookupConnection(cCib)
+	if cib == nil {
+		return
+	}
+	patchset := C.go_cib_notify_diff(msg)
+	if patchset == nil {
+		return
+	}
+	buffer := C.xmlNode2string(patchset)
+	if buffer == nil {
+		log.Errorf("Error: Failed to convert patchset")
+		return
+	}
+	diff, err := parseCibDiff(C.GoString(buffer))
+	C.free(unsafe.Pointer(buffer))
+	if err != nil {
+		log.Errorf("%s", err)
+		return
+	}
+	cib.lock.Lock()
+	version := diff.Target
+	cib.version = &version
+	cib.lock.Unlock()
+
+	var doc *CibDocument
+	subscribers := cib.callbacks()
+	for _, sub := range subscribers {
+		if sub.withDocument {
+			doc, err = cib.updateDocument(msg)
+			if err != nil {
+				log.Errorf("Failed to query CIB after update: %s", err)
+			}
+			break
+		}
+	}
+	for _, sub := range subscribers {
+		if !sub.withDocument {
+			sub.callback(UpdateEvent, diff, nil)
+		} else if doc != nil {
+			sub.callback(UpdateEvent, diff, doc)
+		}
+	}
+	if doc != nil {
+		// The document wraps cib.current, which is
+		// freed by the next update, so it must not
+		// be used once the callbacks return.
+		doc.Close()
+	}
+}
+
+//export callResultCallback
+func callResultCallback(id C.long, rc C.int, output *C.xmlNode) {
+	res := callResult{rc: int(rc)}
+	if output != nil && rc >= C.pcmk_ok {
+		// The output belongs to pacemaker
+		res.output = C.copy_xml(output)
+	}
+	if !completeCall(id, res) && res.output != nil {
+		C.free_xml(res.output)
 	}
 }
 
 //export destroyNotifyCallback
-func destroyNotifyCallback() {
-	for _, callback := range the_cib.subscribers {
-		callback(DestroyEvent, nil)
+func destroyNotifyCallback(cCib *C.cib_t) {
+	cib := lookupConnection(cCib)
+	if cib == nil {
+		return
+	}
+	cib.lock.Lock()
+	cib.dropDocument()
+	stop := cib.stop
+	cib.lock.Unlock()
+	event := DestroyEvent
+	if stop != nil {
+		event = DisconnectedEvent
+		go cib.reconnect(*cib.config.reconnect, stop)
+	}
+	for _, sub := range cib.callbacks() {
+		sub.callback(event, nil, nil)
 	}
 }
 
-//export goMainloopSched
-func goMainloopSched() {
-	runtime.Gosched()
+// Tries to sign on again after the connection
+// was lost, until it succeeds, the policy gives
+// up or the connection is closed. Waits between
+// attempts in its own goroutine, but makes each
+// attempt from the main loop, so that the
+// subscribers are also called from there.
+func (cib *Cib) reconnect(policy ReconnectPolicy, stop chan struct{}) {
+	for failures := 0; policy.retry(failures); failures++ {
+		select {
+		case <-stop:
+			return
+		case <-time.After(policy.delay(failures + 1)):
+		}
+		done := make(chan error, 1)
+		invokeMainloop(func() {
+			done <- cib.reconnectAttempt(stop)
+		})
+		select {
+		case <-stop:
+			return
+		case err := <-done:
+			if err == nil {
+				return
+			}
+			log.Errorf("Failed to reconnect to CIB: %s", err)
+		}
+	}
+	invokeMainloop(func() {
+		cib.lock.Lock()
+		stopped := cib.stop != stop
+		cib.lock.Unlock()
+		if stopped {
+			return
+		}
+		log.Errorf("Giving up reconnecting to CIB")
+		for _, sub := range cib.callbacks() {
+			sub.callback(DestroyEvent, nil, nil)
+		}
+	})
+}
+
+// Makes one attempt to reconnect and reports
+// it to the subscribers. Runs from the main loop.
+func (cib *Cib) reconnectAttempt(stop chan struct{}) error {
+	diff, doc, err := cib.resignon(stop)
+	if err != nil || diff == nil {
+		return err
+	}
+	for _, sub := range cib.callbacks() {
+		if !sub.withDocument {
+			sub.callback(ReconnectedEvent, diff, nil)
+		} else if doc != nil {
+			sub.callback(ReconnectedEvent, diff, doc)
+		}
+	}
+	if doc != nil {
+		doc.Close()
+	}
+	return nil
 }
 
+// Signs on again and re-registers for
+// notifications. Returns the version gap, and
+// the current CIB if any subscriber wants it,
+// or a nil diff if the connection was closed.
+func (cib *Cib) resignon(stop chan struct{}) (*CibDiff, *CibDocument, error) {
+	cib.lock.Lock()
+	defer cib.lock.Unlock()
+	if cib.stop != stop {
+		return nil, nil, nil
+	}
+	C.go_cib_signoff(cib.cCib)
+	rc := C.go_cib_signon(cib.cCib, C.crm_system_name, (uint32)(cib.config.connection))
+	if rc != C.pcmk_ok {
+		return nil, nil, formatErrorRc("signon", (int)(rc))
+	}
+	if cib.subscribers != nil {
+		cib.notifications = uint(C.go_cib_register_notify_callbacks(cib.cCib, cib.notify))
+	}
+
+	diff := &CibDiff{}
+	if cib.version != nil {
+		diff.Source = *cib.version
+	}
+	version, err := cib.queryVersion()
+	if err != nil {
+		return nil, nil, err
+	}
+	diff.Target = *version
+	cib.version = version
+
+	var doc *CibDocument
+	for _, sub := range cib.subscribers {
+		if sub.withDocument {
+			root, err := cib.queryLocked("", false)
+			if err != nil {
+				return nil, nil, err
+			}
+			cib.current = root
+			doc = &CibDocument{xml: cib.current, borrowed: true}
+			break
+		}
+	}
+	return diff, doc, nil
+}
+
+// Functions waiting to be run from the main
+// loop, by the id passed to go_mainloop_invoke.
+var mainloopCalls = struct {
+	sync.Mutex
+	nextId C.long
+	calls  map[C.long]func()
+}{calls: make(map[C.long]func())}
+
+// Runs fn from the main loop, so that it does
+// not call into pacemaker while the main loop is
+// dispatching callbacks on another thread. If no
+// main loop is running, fn is run at once.
+func invokeMainloop(fn func()) {
+	mainloopCalls.Lock()
+	mainloopCalls.nextId++
+	id := mainloopCalls.nextId
+	mainloopCalls.calls[id] = fn
+	mainloopCalls.Unlock()
+	C.go_mainloop_invoke(id)
+}
+
+//export mainloopInvokeCallback
+func mainloopInvokeCallback(id C.long) {
+	mainloopCalls.Lock()
+	fn := mainloopCalls.calls[id]
+	delete(mainloopCalls.calls, id)
+	mainloopCalls.Unlock()
+	if fn != nil {
+		fn()
+	}
+}
+
+// Runs the glib main loop, which delivers CIB
+// notifications to subscribers. Blocks forever;
+// use MainloopContext to be able to stop it.
 func Mainloop() {
+	MainloopContext(context.Background())
+}
+
+// Runs the glib main loop until the context is
+// cancelled, and returns the context's error.
+//
+// The loop sleeps in poll() on the file
+// descriptors of the CIB connections and only
+// wakes up to dispatch events, so an idle loop
+// does not consume any CPU. Subscriber
+// callbacks are called from the thread running
+// the loop.
+func MainloopContext(ctx context.Context) error {
 	mainloop := C.g_main_loop_new(nil, C.FALSE)
-	C.go_add_idle_scheduler(mainloop)
+	defer C.g_main_loop_unref(mainloop)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			C.go_mainloop_quit(mainloop)
+		case <-done:
+		}
+	}()
 	C.g_main_loop_run(mainloop)
-	C.g_main_loop_unref(mainloop)
+	return ctx.Err()
 }
@@ -3,12 +3,17 @@
 package pacemaker_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/ClusterLabs/go-pacemaker"
 	"gopkg.in/xmlpath.v2"
+	"io/ioutil"
 	"log"
+	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestXmlpath(t *testing.T) {
@@ -91,3 +96,647 @@ func ExampleQueryXPath() {
 	fmt.Printf("%s\n", doc.ToString())
 	// Output: <node id="xxx" uname="c001n01" type="normal"/>
 }
+
+// Opens a copy of testdata/simple.xml that the
+// test may modify. The returned function closes
+// the connection and removes the copy.
+func openWritableCib(t *testing.T) (*pacemaker.Cib, func()) {
+	t.Helper()
+	file, err := ioutil.TempFile("", "cib-*.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile("testdata/simple.xml")
+	if err == nil {
+		_, err = file.Write(data)
+	}
+	file.Close()
+	if err != nil {
+		os.Remove(file.Name())
+		t.Fatal(err)
+	}
+
+	cib, err := pacemaker.OpenCib(pacemaker.FromFile(file.Name()), pacemaker.ForCommand)
+	if err != nil {
+		os.Remove(file.Name())
+		t.Fatal(err)
+	}
+	return cib, func() {
+		cib.Close()
+		os.Remove(file.Name())
+	}
+}
+
+func TestCreateDelete(t *testing.T) {
+	cib, cleanup := openWritableCib(t)
+	defer cleanup()
+
+	err := cib.Create("constraints", `<rsc_location id="myAddr-avoid" rsc="myAddr" node="c001n02" score="-INFINITY"/>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := cib.QueryXPath("//constraints/rsc_location[@id='myAddr-avoid']")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc.Close()
+
+	err = cib.Delete("//constraints/rsc_location[@id='myAddr-avoid']", "", pacemaker.CallSync|pacemaker.CallXPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cib.QueryXPath("//constraints/rsc_location[@id='myAddr-avoid']")
+	if err == nil {
+		t.Error("Expected constraint to be deleted")
+	}
+}
+
+func TestSubscribeUnsubscribe(t *testing.T) {
+	cib, err := pacemaker.OpenCib(pacemaker.FromFile("testdata/simple.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cib.Close()
+
+	callback := func(event pacemaker.CibEvent, doc *pacemaker.CibDocument) {}
+	first, err := cib.Subscribe(callback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := cib.Subscribe(callback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Unsubscribe()
+	third, err := cib.Subscribe(callback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.Id() == second.Id() {
+		t.Errorf("Subscription id %d reused", third.Id())
+	}
+	if len(cib.Subscribers()) != 2 {
+		t.Errorf("Expected 2 subscribers, got %d", len(cib.Subscribers()))
+	}
+	second.Unsubscribe()
+	third.Unsubscribe()
+	if len(cib.Subscribers()) != 0 {
+		t.Errorf("Expected no subscribers, got %d", len(cib.Subscribers()))
+	}
+}
+
+func TestSubscribeMultipleConnections(t *testing.T) {
+	callback := func(event pacemaker.CibEvent, doc *pacemaker.CibDocument) {}
+	for i := 0; i < 20; i++ {
+		cib, err := pacemaker.OpenCib(pacemaker.FromFile("testdata/simple.xml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cib.Close()
+		if _, err := cib.Subscribe(callback); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestEventsCancel(t *testing.T) {
+	cib, err := pacemaker.OpenCib(pacemaker.FromFile("testdata/simple.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cib.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := cib.Events(ctx, pacemaker.WithBuffer(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cib.Subscribers()) != 1 {
+		t.Errorf("Expected 1 subscriber, got %d", len(cib.Subscribers()))
+	}
+	cancel()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("Expected no events")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Channel not closed after cancel")
+	}
+}
+
+func TestSubscribeDiffRejectsBuffer(t *testing.T) {
+	cib, err := pacemaker.OpenCib(pacemaker.FromFile("testdata/simple.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cib.Close()
+	callback := func(event pacemaker.CibEvent, diff *pacemaker.CibDiff, doc *pacemaker.CibDocument) {}
+	if _, err := cib.SubscribeDiff(callback, pacemaker.WithBuffer(8)); err == nil {
+		t.Error("Expected WithBuffer to be rejected")
+	}
+	if len(cib.Subscribers()) != 0 {
+		t.Errorf("Expected no subscribers, got %d", len(cib.Subscribers()))
+	}
+}
+
+func TestReconnectClose(t *testing.T) {
+	cib, err := pacemaker.OpenCib(pacemaker.FromFile("testdata/simple.xml"), pacemaker.WithReconnect(pacemaker.DefaultReconnectPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := cib.Subscribe(func(event pacemaker.CibEvent, doc *pacemaker.CibDocument) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub.Unsubscribe()
+	if err := cib.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMainloopContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := pacemaker.MainloopContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected %s, got %v", context.DeadlineExceeded, err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("Mainloop did not stop when the context expired")
+	}
+
+	// A loop started with a cancelled context
+	// returns immediately.
+	if err := pacemaker.MainloopContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected %s, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestDocumentClose(t *testing.T) {
+	pacemaker.TrackDocuments(true)
+	defer pacemaker.TrackDocuments(false)
+
+	cib, err := pacemaker.OpenCib(pacemaker.FromFile("testdata/simple.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cib.Close()
+	doc, err := cib.Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy := doc.Copy()
+	if n := len(pacemaker.OpenDocuments()); n != 2 {
+		t.Errorf("Expected 2 open documents, got %d", n)
+	}
+	doc.Close()
+	doc.Close()
+	if doc.ToString() != "" || doc.Version() != nil || doc.Copy() != nil {
+		t.Error("Expected closed document to be empty")
+	}
+	if copy.ToString() == "" {
+		t.Error("Expected copy to survive closing the original")
+	}
+	copy.Close()
+	if n := len(pacemaker.OpenDocuments()); n != 0 {
+		t.Errorf("Expected no open documents, got %d", n)
+	}
+
+	if err := cib.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cib.Close(); err != nil {
+		t.Errorf("Expected second Close to succeed, got %s", err)
+	}
+}
+
+func TestDocumentElement(t *testing.T) {
+	cib, err := pacemaker.OpenCib(pacemaker.FromFile("testdata/simple.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cib.Close()
+	doc, err := cib.Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := doc.Element()
+	doc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Name != "cib" || root.FindById("option-1") == nil {
+		t.Errorf("Unexpected document: %s", root)
+	}
+	if _, err := doc.Element(); err == nil {
+		t.Error("Expected error converting a closed document")
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	cib, err := pacemaker.OpenCib(pacemaker.FromFile("testdata/simple.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cib.QueryXPath("//primitive[@id='no-such-resource']")
+	if !errors.Is(err, pacemaker.ErrNoSuchObject) {
+		t.Errorf("Expected ErrNoSuchObject, got %v", err)
+	}
+	var cibErr *pacemaker.CibError
+	if !errors.As(err, &cibErr) || cibErr.Operation != "query" || cibErr.Name == "" {
+		t.Errorf("Expected structured error, got %#v", err)
+	}
+	cib.Close()
+	if _, err := cib.Query(); !errors.Is(err, pacemaker.ErrNotConnected) {
+		t.Errorf("Expected ErrNotConnected, got %v", err)
+	}
+}
+
+func TestQueryContext(t *testing.T) {
+	cib, err := pacemaker.OpenCib(pacemaker.FromFile("testdata/simple.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cib.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	doc, err := cib.QueryContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Version() == nil {
+		t.Error("Expected a complete CIB")
+	}
+	doc.Close()
+	_, err = cib.QueryXPathContext(ctx, "//primitive[@id='no-such-resource']")
+	if !errors.Is(err, pacemaker.ErrNoSuchObject) {
+		t.Errorf("Expected ErrNoSuchObject, got %v", err)
+	}
+
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	_, err = cib.QueryContext(expired)
+	if !errors.Is(err, pacemaker.ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected timeout, got %v", err)
+	}
+
+	// Without a deadline, pacemaker is given a
+	// default timeout for the call.
+	doc, err = cib.QueryContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc.Close()
+	cib.Close()
+	if _, err := cib.QueryContext(context.Background()); !errors.Is(err, pacemaker.ErrNotConnected) {
+		t.Errorf("Expected ErrNotConnected, got %v", err)
+	}
+}
+
+func TestConnectionInfo(t *testing.T) {
+	cib, err := pacemaker.OpenCib(pacemaker.FromFile("testdata/simple.xml"), pacemaker.ForQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := cib.ConnectionInfo()
+	if info.Backend != pacemaker.BackendFile || info.Target != "testdata/simple.xml" || info.Connection != pacemaker.Query || !info.Connected {
+		t.Errorf("Unexpected connection info: %+v", info)
+	}
+	cib.Close()
+	if cib.ConnectionInfo().Connected {
+		t.Error("Expected closed connection not to be connected")
+	}
+
+	cib, err = pacemaker.OpenCib(pacemaker.FromFile("testdata/simple.xml"), pacemaker.ForNoConnection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info = cib.ConnectionInfo()
+	if info.Connection != pacemaker.NoConnection || info.Connected {
+		t.Errorf("Expected connection without signon, got %+v", info)
+	}
+	cib.Close()
+
+	os.Setenv("CIB_file", "")
+	os.Setenv("CIB_port", "")
+	defer os.Unsetenv("CIB_file")
+	defer os.Unsetenv("CIB_port")
+	cib, err = pacemaker.OpenCib(pacemaker.ForNoConnection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info := cib.ConnectionInfo(); info.Backend != pacemaker.BackendNative {
+		t.Errorf("Expected empty CIB_file and CIB_port to be ignored, got %+v", info)
+	}
+	cib.Close()
+}
+
+func TestOpenCibInvalidOptions(t *testing.T) {
+	for _, options := range [][]func(*pacemaker.CibOpenConfig){
+		{pacemaker.FromFile("testdata/simple.xml"), pacemaker.ForCommandNonBlocking},
+		{pacemaker.FromShadow("test"), pacemaker.ForCommandNonBlocking},
+		{pacemaker.FromFile("testdata/simple.xml"), pacemaker.FromShadow("test")},
+	} {
+		cib, err := pacemaker.OpenCib(options...)
+		if err == nil {
+			cib.Close()
+			t.Errorf("Expected error for invalid options")
+		}
+	}
+}
+
+func TestShadowLifecycle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-pacemaker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data, err := ioutil.ReadFile("testdata/simple.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := dir + "/live.xml"
+	if err := ioutil.WriteFile(live, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Use the file as the live CIB.
+	os.Setenv("CIB_file", live)
+	os.Setenv("CIB_shadow_dir", dir)
+	defer os.Unsetenv("CIB_file")
+	defer os.Unsetenv("CIB_shadow_dir")
+
+	shadow, err := pacemaker.CreateShadow("test", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pacemaker.CreateShadow("test", true); !errors.Is(err, pacemaker.ErrExists) {
+		t.Errorf("Expected ErrExists creating an existing shadow, got %v", err)
+	}
+	if shadows, err := pacemaker.ListShadows(); err != nil || len(shadows) != 1 || shadows[0].Name() != "test" {
+		t.Errorf("Unexpected shadows: %v %v", shadows, err)
+	}
+	if diff, err := shadow.Diff(); err != nil || diff != nil {
+		t.Errorf("Expected no changes in new shadow, got %v %v", diff, err)
+	}
+
+	constraint := `<rsc_location id="myAddr-avoid" rsc="myAddr" node="c001n02" score="-INFINITY"/>`
+	stage := func() {
+		cib, err := shadow.Open(pacemaker.ForCommand)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cib.Create("constraints", constraint); err != nil {
+			t.Fatal(err)
+		}
+		cib.Close()
+	}
+	stage()
+	diff, err := shadow.Diff()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff == nil || len(diff.Added()) != 1 {
+		t.Errorf("Expected one added element, got %v", diff)
+	}
+	if err := shadow.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if diff, err := shadow.Diff(); err != nil || diff != nil {
+		t.Errorf("Expected no changes after reset, got %v %v", diff, err)
+	}
+
+	stage()
+	// Committing would lose changes made to the
+	// live CIB since the shadow was reset.
+	cib, err := pacemaker.OpenCib(pacemaker.FromFile(live))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cib.Create("constraints", `<rsc_location id="live-change" rsc="myAddr" node="c001n01" score="10"/>`); err != nil {
+		t.Fatal(err)
+	}
+	cib.Close()
+	// The version the shadow was reset from is
+	// kept in the shadow itself.
+	shadow, err = pacemaker.FindShadow("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := shadow.Commit(); !errors.Is(err, pacemaker.ErrVersionConflict) {
+		t.Errorf("Expected ErrVersionConflict, got %v", err)
+	}
+	if err := shadow.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	stage()
+	if err := shadow.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	cib, err = pacemaker.OpenCib(pacemaker.FromFile(live))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := cib.QueryXPath("//constraints/rsc_location[@id='myAddr-avoid']")
+	if err != nil {
+		t.Errorf("Expected constraint to be committed: %s", err)
+	} else {
+		doc.Close()
+	}
+	cib.Close()
+
+	if err := shadow.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pacemaker.FindShadow("test"); !errors.Is(err, pacemaker.ErrNoSuchObject) {
+		t.Errorf("Expected deleted shadow to be gone, got %v", err)
+	}
+	if err := shadow.Delete(); !errors.Is(err, pacemaker.ErrNoSuchObject) {
+		t.Errorf("Expected ErrNoSuchObject deleting a deleted shadow, got %v", err)
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	cib, err := pacemaker.OpenCib(pacemaker.FromFile("testdata/simple.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cib.Close()
+	doc, err := cib.Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+	graph, err := pacemaker.Simulate(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, action := range graph.Actions {
+		for _, input := range action.Inputs {
+			if graph.Action(input) == nil {
+				t.Errorf("Action %d depends on unknown action %d", action.Id, input)
+			}
+		}
+	}
+}
+
+func TestExplainPlacement(t *testing.T) {
+	cib, err := pacemaker.OpenCib(pacemaker.FromFile("testdata/simple.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cib.Close()
+	placement, err := cib.ExplainPlacement("myAddr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(placement.Scores) == 0 {
+		t.Error("Expected allocation scores for myAddr")
+	}
+	if _, err := cib.ExplainPlacement("missing"); !errors.Is(err, pacemaker.ErrNoSuchObject) {
+		t.Errorf("Expected ErrNoSuchObject, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/simple.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := pacemaker.ParseDocument(string(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+	errs, err := doc.Validate()
+	if err != nil || len(errs) != 0 {
+		t.Fatalf("Expected valid document, got %v %v", errs, err)
+	}
+
+	invalid, err := pacemaker.ParseDocument(strings.Replace(string(data), `class="ocf" `, "", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer invalid.Close()
+	errs, err = invalid.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) == 0 {
+		t.Fatal("Expected validation errors for primitive without class")
+	}
+	for _, e := range errs {
+		if e.Line == 0 || e.Message == "" {
+			t.Errorf("Expected line and message, got %+v", e)
+		}
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/simple.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := pacemaker.ParseDocument(string(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+
+	upgraded, err := doc.Upgrade("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upgraded.Close()
+	if !strings.Contains(upgraded.ToString(), `validate-with="pacemaker-3.`) {
+		t.Errorf("Expected upgrade to the latest schema, got %s", upgraded.ToString())
+	}
+	if errs, err := upgraded.Validate(); err != nil || len(errs) != 0 {
+		t.Errorf("Expected upgraded document to be valid, got %v %v", errs, err)
+	}
+	if !strings.Contains(doc.ToString(), `validate-with="pacemaker-1.2"`) {
+		t.Error("Expected original document to be unchanged")
+	}
+
+	if _, err := doc.Upgrade("pacemaker-0.1"); err == nil {
+		t.Error("Expected error for unknown schema")
+	}
+	if _, err := upgraded.Upgrade("pacemaker-1.2"); err == nil {
+		t.Error("Expected error when downgrading")
+	}
+}
+
+func TestCreateBuilt(t *testing.T) {
+	cib, cleanup := openWritableCib(t)
+	defer cleanup()
+
+	web := pacemaker.NewPrimitive("web", "ocf:heartbeat:apache").
+		Param("configfile", "/etc/httpd/conf/httpd.conf").
+		Op("monitor", "30s", pacemaker.OpWithTimeout("20s"))
+	rsc, err := web.ToXML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cib.Create("resources", rsc); err != nil {
+		t.Fatal(err)
+	}
+	colocation, err := pacemaker.NewColocation("web", "myAddr", pacemaker.ScoreInfinity).ToXML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cib.Create("constraints", colocation); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := cib.Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+	conf, err := doc.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := conf.FindResource("web"); r == nil || len(r.Operations) != 1 {
+		t.Errorf("Expected web with one operation, got %+v", r)
+	}
+	if len(conf.Constraints.Colocations) != 1 {
+		t.Errorf("Expected one colocation, got %d", len(conf.Constraints.Colocations))
+	}
+}
+
+func TestSetProperty(t *testing.T) {
+	cib, cleanup := openWritableCib(t)
+	defer cleanup()
+
+	if err := cib.SetProperty("stonith-enabled", "true"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cib.SetProperty("maintenance-mode", "yes"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cib.UnsetProperty("no-quorum-policy"); err != nil {
+		t.Fatal(err)
+	}
+	props, err := cib.ClusterProperties()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := props.Values["stonith-enabled"]; !props.Bool("stonith-enabled") || v.Source != "option-3" {
+		t.Errorf("Expected stonith-enabled to be updated in place, got %+v", v)
+	}
+	if v := props.Values["maintenance-mode"]; !props.Bool("maintenance-mode") || v.Source != "cib-bootstrap-options-maintenance-mode" {
+		t.Errorf("Expected maintenance-mode to be added, got %+v", v)
+	}
+	if v := props.Values["no-quorum-policy"]; !v.IsDefault() {
+		t.Errorf("Expected no-quorum-policy to be unset, got %+v", v)
+	}
+
+	for _, bad := range [][2]string{{"no-such-option", "1"}, {"dc-version", "2.1"}, {"stonith-timeout", "soon"}} {
+		var cerr *pacemaker.CibError
+		if err := cib.SetProperty(bad[0], bad[1]); !errors.As(err, &cerr) || cerr.Name != "EINVAL" {
+			t.Errorf("Expected EINVAL setting %s=%s, got %v", bad[0], bad[1], err)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The type of the value of a cluster property.
+type PropertyType string
+
+const (
+	BooleanProperty  PropertyType = "boolean"
+	DurationProperty PropertyType = "time"
+	EnumProperty     PropertyType = "select"
+	IntegerProperty  PropertyType = "integer"
+	ScoreProperty    PropertyType = "score"
+	StringProperty   PropertyType = "string"
+)
+
+// The id of the property set Pacemaker itself
+// reads and writes cluster properties in.
+const bootstrapOptions = "cib-bootstrap-options"
+
+// A cluster option known to Pacemaker. Values
+// lists the allowed values of an enum.
+// Generated properties are maintained by the
+// cluster and should not be set by hand.
+type PropertyDefinition struct {
+	Name       string
+	Type       PropertyType
+	Default    string
+	Values     []string
+	Generated  bool
+	Deprecated bool
+	// What to use instead of a deprecated
+	// property, if anything.
+	ReplacedBy string
+	// Whether a duration may be negative, as
+	// for stonith-watchdog-timeout, where a
+	// negative value has the timeout computed
+	// from the SBD watchdog timeout.
+	Signed bool
+}
+
+// The cluster options of Pacemaker 2.1 and
+// their defaults, as listed by
+// "pacemaker-schedulerd metadata" and
+// "pacemaker-controld metadata".
+var ClusterOptions = []*PropertyDefinition{
+	{Name: "dc-version", Type: StringProperty, Default: "none", Generated: true},
+	{Name: "cluster-infrastructure", Type: StringProperty, Default: "corosync", Generated: true},
+	{Name: "have-watchdog", Type: BooleanProperty, Default: "false", Generated: true},
+	{Name: "last-lrm-refresh", Type: StringProperty, Generated: true},
+	{Name: "cluster-name", Type: StringProperty},
+	{Name: "dc-deadtime", Type: DurationProperty, Default: "20s"},
+	{Name: "cluster-recheck-interval", Type: DurationProperty, Default: "15min"},
+	{Name: "load-threshold", Type: StringProperty, Default: "80%"},
+	{Name: "node-action-limit", Type: IntegerProperty, Default: "0"},
+	{Name: "fence-reaction", Type: EnumProperty, Default: "stop", Values: []string{"stop", "panic"}},
+	{Name: "election-timeout", Type: DurationProperty, Default: "2min"},
+	{Name: "shutdown-escalation", Type: DurationProperty, Default: "20min"},
+	{Name: "join-integration-timeout", Type: DurationProperty, Default: "3min"},
+	{Name: "join-finalization-timeout", Type: DurationProperty, Default: "30min"},
+	{Name: "transition-delay", Type: DurationProperty, Default: "0s"},
+	{Name: "no-quorum-policy", Type: EnumProperty, Default: "stop", Values: []string{"stop", "freeze", "ignore", "demote", "suicide"}},
+	{Name: "shutdown-lock", Type: BooleanProperty, Default: "false"},
+	{Name: "shutdown-lock-limit", Type: DurationProperty, Default: "0"},
+	{Name: "symmetric-cluster", Type: BooleanProperty, Default: "true"},
+	{Name: "maintenance-mode", Type: BooleanProperty, Default: "false"},
+	{Name: "start-failure-is-fatal", Type: BooleanProperty, Default: "true"},
+	{Name: "enable-startup-probes", Type: BooleanProperty, Default: "true"},
+	{Name: "stonith-enabled", Type: BooleanProperty, Default: "true"},
+	{Name: "stonith-action", Type: EnumProperty, Default: "reboot", Values: []string{"reboot", "off", "poweroff"}},
+	{Name: "stonith-timeout", Type: DurationProperty, Default: "60s"},
+	{Name: "stonith-watchdog-timeout", Type: DurationProperty, Default: "0", Signed: true},
+	{Name: "stonith-max-attempts", Type: IntegerProperty, Default: "10"},
+	{Name: "concurrent-fencing", Type: BooleanProperty, Default: "false"},
+	{Name: "startup-fencing", Type: BooleanProperty, Default: "true"},
+	{Name: "priority-fencing-delay", Type: DurationProperty, Default: "0"},
+	{Name: "node-health-strategy", Type: EnumProperty, Default: "none", Values: []string{"none", "migrate-on-red", "only-green", "progressive", "custom"}},
+	{Name: "node-health-base", Type: IntegerProperty, Default: "0"},
+	{Name: "node-health-green", Type: ScoreProperty, Default: "0"},
+	{Name: "node-health-yellow", Type: ScoreProperty, Default: "0"},
+	{Name: "node-health-red", Type: ScoreProperty, Default: "-INFINITY"},
+	{Name: "placement-strategy", Type: EnumProperty, Default: "default", Values: []string{"default", "utilization", "minimal", "balanced"}},
+	{Name: "stop-all-resources", Type: BooleanProperty, Default: "false"},
+	{Name: "stop-orphan-resources", Type: BooleanProperty, Default: "true"},
+	{Name: "stop-orphan-actions", Type: BooleanProperty, Default: "true"},
+	{Name: "batch-limit", Type: IntegerProperty, Default: "0"},
+	{Name: "migration-limit", Type: IntegerProperty, Default: "-1"},
+	{Name: "cluster-ipc-limit", Type: IntegerProperty, Default: "500"},
+	{Name: "pe-error-series-max", Type: IntegerProperty, Default: "-1"},
+	{Name: "pe-warn-series-max", Type: IntegerProperty, Default: "5000"},
+	{Name: "pe-input-series-max", Type: IntegerProperty, Default: "4000"},
+	{Name: "enable-acl", Type: BooleanProperty, Default: "false"},
+	{Name: "remove-after-stop", Type: BooleanProperty, Default: "false", Deprecated: true},
+	{Name: "default-resource-stickiness", Type: ScoreProperty, Default: "0", Deprecated: true, ReplacedBy: "resource-stickiness in rsc_defaults"},
+	{Name: "is-managed-default", Type: BooleanProperty, Default: "true", Deprecated: true, ReplacedBy: "is-managed in rsc_defaults"},
+	{Name: "default-action-timeout", Type: DurationProperty, Default: "20s", Deprecated: true, ReplacedBy: "timeout in op_defaults"},
+}
+
+// Looks up the definition of a cluster option,
+// or returns nil if the name is unknown.
+func FindClusterOption(name string) *PropertyDefinition {
+	for _, def := range ClusterOptions {
+		if def.Name == name {
+			return def
+		}
+	}
+	return nil
+}
+
+// Parses a value of the type of the option:
+// bool for booleans, time.Duration for times,
+// int for integers, Score for scores, and
+// string otherwise.
+func (def *PropertyDefinition) Parse(value string) (interface{}, error) {
+	invalid := &CibError{Code: -int(syscall.EINVAL), Name: "EINVAL", msg: "Invalid value for " + def.Name + ": " + value}
+	switch def.Type {
+	case BooleanProperty:
+		switch strings.ToLower(value) {
+		case "true", "on", "yes", "y", "1":
+			return true, nil
+		case "false", "off", "no", "n", "0":
+			return false, nil
+		}
+		return nil, invalid
+	case DurationProperty:
+		trimmed := strings.TrimSpace(value)
+		negative := def.Signed && strings.HasPrefix(trimmed, "-")
+		if negative {
+			trimmed = trimmed[1:]
+		}
+		d, err := ParseInterval(trimmed)
+		if err != nil {
+			return nil, invalid
+		}
+		if negative {
+			d = -d
+		}
+		return d, nil
+	case IntegerProperty:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, invalid
+		}
+		return n, nil
+	case ScoreProperty:
+		n, err := ParseScore(value)
+		if err != nil {
+			return nil, invalid
+		}
+		return n, nil
+	case EnumProperty:
+		for _, v := range def.Values {
+			if strings.EqualFold(v, value) {
+				return v, nil
+			}
+		}
+		return nil, invalid
+	}
+	return value, nil
+}
+
+// The value of a cluster option. Source is the
+// id of the nvpair the value was taken from, or
+// empty if the default applies.
+type PropertyValue struct {
+	Definition *PropertyDefinition
+	Raw        string
+	Value      interface{}
+	Source     string
+}
+
+// Returns true if the option is not set in the
+// configuration.
+func (v *PropertyValue) IsDefault() bool {
+	return v.Source == ""
+}
+
+// The cluster options in effect for a
+// configuration.
+type ClusterProperties struct {
+	// The value of every known option, keyed
+	// by name, with defaults applied.
+	Values map[string]*PropertyValue
+	// Properties set in the configuration that
+	// Pacemaker does not know.
+	Unknown []*Nvpair
+	// Deprecated properties set in the
+	// configuration.
+	Deprecated []*Nvpair
+	// Properties with a value that does not
+	// parse as the type of the option, which
+	// Pacemaker replaces with the default.
+	Invalid []*Nvpair
+}
+
+// Resolves the cluster options of the
+// configuration at the current time. As in
+// Pacemaker, cib-bootstrap-options takes
+// precedence over other property sets, and
+// the rest are applied by score.
+func (conf *Configuration) ClusterProperties() (*ClusterProperties, error) {
+	values, err := evaluateAttributeSets(conf.CrmConfig, &RuleContext{}, bootstrapOptions)
+	if err != nil {
+		return nil, err
+	}
+	props := &ClusterProperties{Values: make(map[string]*PropertyValue)}
+	for _, def := range ClusterOptions {
+		v := &PropertyValue{Definition: def, Raw: def.Default}
+		if nv, ok := values[def.Name]; ok {
+			if value, err := def.Parse(nv.Value); err == nil {
+				v.Raw, v.Value, v.Source = nv.Value, value, nv.Id
+			} else {
+				props.Invalid = append(props.Invalid, nv)
+			}
+			if def.Deprecated {
+				props.Deprecated = append(props.Deprecated, nv)
+			}
+		}
+		if v.Source == "" && def.Default != "" {
+			v.Value, _ = def.Parse(def.Default)
+		}
+		props.Values[def.Name] = v
+	}
+	for name, nv := range values {
+		if FindClusterOption(name) == nil {
+			props.Unknown = append(props.Unknown, nv)
+		}
+	}
+	byName := func(nvs []*Nvpair) {
+		sort.Slice(nvs, func(i, j int) bool { return nvs[i].Name < nvs[j].Name })
+	}
+	byName(props.Unknown)
+	byName(props.Deprecated)
+	byName(props.Invalid)
+	return props, nil
+}
+
+// Returns the value of a boolean option, or
+// false if the option is not a boolean.
+func (props *ClusterProperties) Bool(name string) bool {
+	b, _ := props.value(name).(bool)
+	return b
+}
+
+// Returns the value of a time option, or zero
+// if the option is not a time.
+func (props *ClusterProperties) Duration(name string) time.Duration {
+	d, _ := props.value(name).(time.Duration)
+	return d
+}
+
+// Returns the value of an integer option, or
+// zero if the option is not an integer.
+func (props *ClusterProperties) Int(name string) int {
+	n, _ := props.value(name).(int)
+	return n
+}
+
+// Returns the value of a score option, or zero
+// if the option is not a score.
+func (props *ClusterProperties) Score(name string) Score {
+	n, _ := props.value(name).(Score)
+	return n
+}
+
+// Returns the value of an option as set in the
+// configuration, or its default.
+func (props *ClusterProperties) String(name string) string {
+	if v, ok := props.Values[name]; ok {
+		return v.Raw
+	}
+	return ""
+}
+
+func (props *ClusterProperties) value(name string) interface{} {
+	if v, ok := props.Values[name]; ok {
+		return v.Value
+	}
+	return nil
+}
+
+// Checks that the property is a known,
+// settable cluster option and that the value
+// is valid for it.
+func checkClusterProperty(name, value string) error {
+	def := FindClusterOption(name)
+	if def == nil {
+		return &CibError{Code: -int(syscall.EINVAL), Name: "EINVAL", Operation: "set property", msg: "Unknown cluster property " + name}
+	}
+	if def.Generated {
+		return &CibError{Code: -int(syscall.EINVAL), Name: "EINVAL", Operation: "set property", msg: "Cluster property " + name + " is maintained by the cluster"}
+	}
+	if _, err := def.Parse(value); err != nil {
+		cerr := err.(*CibError)
+		cerr.Operation = "set property"
+		return cerr
+	}
+	return nil
+}
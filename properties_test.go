@@ -0,0 +1,94 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClusterProperties(t *testing.T) {
+	conf := loadConfiguration(t, "testdata/simple.xml")
+	conf.CrmConfig = append(conf.CrmConfig, &AttributeSet{
+		Id:    "extra-options",
+		Score: ScoreInfinity,
+		Nvpairs: []*Nvpair{
+			{Id: "extra-1", Name: "stonith-enabled", Value: "true"},
+			{Id: "extra-2", Name: "stonith-timeout", Value: "2min"},
+			{Id: "extra-3", Name: "default-resource-stickiness", Value: "100"},
+			{Id: "extra-4", Name: "my-option", Value: "1"},
+			{Id: "extra-5", Name: "placement-strategy", Value: "clever"},
+		},
+	})
+	props, err := conf.ClusterProperties()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(props.Values) != len(ClusterOptions) {
+		t.Errorf("Expected %d values, got %d", len(ClusterOptions), len(props.Values))
+	}
+
+	// cib-bootstrap-options wins over higher scored sets.
+	if props.Bool("stonith-enabled") || props.Values["stonith-enabled"].Source != "option-3" {
+		t.Errorf("Expected stonith-enabled from option-3, got %+v", props.Values["stonith-enabled"])
+	}
+	if !props.Bool("symmetric-cluster") || props.String("no-quorum-policy") != "stop" {
+		t.Error("Unexpected symmetric-cluster or no-quorum-policy")
+	}
+	if props.Duration("stonith-timeout") != 2*time.Minute {
+		t.Errorf("Expected stonith-timeout 2min, got %v", props.Duration("stonith-timeout"))
+	}
+	if v := props.Values["cluster-recheck-interval"]; !v.IsDefault() || props.Duration("cluster-recheck-interval") != 15*time.Minute {
+		t.Errorf("Expected default cluster-recheck-interval, got %+v", v)
+	}
+	if props.Score("node-health-red") != ScoreMinusInfinity || props.Int("migration-limit") != -1 {
+		t.Error("Unexpected integer defaults")
+	}
+	if v := props.Values["placement-strategy"]; !v.IsDefault() || v.Value != "default" {
+		t.Errorf("Expected invalid placement-strategy to fall back to default, got %+v", v)
+	}
+	if props.Values["cluster-name"].Value != nil {
+		t.Error("Expected no cluster-name")
+	}
+
+	check := func(what string, nvs []*Nvpair, ids ...string) {
+		if len(nvs) != len(ids) {
+			t.Errorf("%s: expected %v, got %d", what, ids, len(nvs))
+			return
+		}
+		for i, id := range ids {
+			if nvs[i].Id != id {
+				t.Errorf("%s: expected %s, got %s", what, id, nvs[i].Id)
+			}
+		}
+	}
+	check("unknown", props.Unknown, "extra-4")
+	check("deprecated", props.Deprecated, "extra-3")
+	check("invalid", props.Invalid, "extra-5")
+}
+
+func TestCheckClusterProperty(t *testing.T) {
+	tests := []struct {
+		name, value string
+		valid       bool
+	}{
+		{"stonith-enabled", "off", true},
+		{"stonith-enabled", "maybe", false},
+		{"no-quorum-policy", "Freeze", true},
+		{"no-quorum-policy", "panic", false},
+		{"dc-deadtime", "PT30S", true},
+		{"stonith-watchdog-timeout", "-1", true},
+		{"stonith-watchdog-timeout", "10s", true},
+		{"dc-deadtime", "-1", false},
+		{"batch-limit", "ten", false},
+		{"node-health-red", "-INFINITY", true},
+		{"dc-version", "2.1.0", false},
+		{"unknown-option", "1", false},
+	}
+	for _, test := range tests {
+		err := checkClusterProperty(test.name, test.value)
+		if (err == nil) != test.valid {
+			t.Errorf("%s=%s: expected valid=%v, got %v", test.name, test.value, test.valid, err)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"time"
+)
+
+// Controls how a connection opened WithReconnect
+// retries after the connection to the CIB is
+// lost. The delay before each attempt starts at
+// InitialDelay and is multiplied by Multiplier
+// after every failed attempt, up to MaxDelay.
+type ReconnectPolicy struct {
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	// Values below 1 keep the delay constant.
+	Multiplier float64
+	// Give up after this many failed attempts,
+	// or never if zero.
+	MaxAttempts int
+}
+
+// Retries forever, starting after one second
+// and backing off to once a minute.
+var DefaultReconnectPolicy = ReconnectPolicy{
+	InitialDelay: time.Second,
+	MaxDelay:     time.Minute,
+	Multiplier:   2,
+}
+
+// Returns the delay before the given attempt,
+// counting from 1.
+func (policy *ReconnectPolicy) delay(attempt int) time.Duration {
+	delay := policy.InitialDelay
+	for i := 1; i < attempt; i++ {
+		if policy.Multiplier <= 1 || (policy.MaxDelay > 0 && delay >= policy.MaxDelay) {
+			break
+		}
+		delay = time.Duration(float64(delay) * policy.Multiplier)
+	}
+	if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	return delay
+}
+
+// Returns true if another attempt should be
+// made after the given number of failures.
+func (policy *ReconnectPolicy) retry(failures int) bool {
+	return policy.MaxAttempts <= 0 || failures < policy.MaxAttempts
+}
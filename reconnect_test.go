@@ -0,0 +1,110 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReconnectDelay(t *testing.T) {
+	policy := ReconnectPolicy{
+		InitialDelay: time.Second,
+		MaxDelay:     10 * time.Second,
+		Multiplier:   2,
+	}
+	expected := []time.Duration{
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		8 * time.Second,
+		10 * time.Second,
+		10 * time.Second,
+	}
+	for i, delay := range expected {
+		if got := policy.delay(i + 1); got != delay {
+			t.Errorf("attempt %d: expected %s, got %s", i+1, delay, got)
+		}
+	}
+
+	policy.Multiplier = 0
+	if got := policy.delay(5); got != time.Second {
+		t.Errorf("Expected constant delay, got %s", got)
+	}
+}
+
+func TestReconnectRetry(t *testing.T) {
+	policy := DefaultReconnectPolicy
+	if !policy.retry(1000) {
+		t.Error("Expected default policy to retry forever")
+	}
+	policy.MaxAttempts = 3
+	if !policy.retry(2) {
+		t.Error("Expected retry after 2 failures")
+	}
+	if policy.retry(3) {
+		t.Error("Expected no retry after 3 failures")
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	policy := ReconnectPolicy{InitialDelay: time.Millisecond, MaxAttempts: 3}
+	cib, err := OpenCib(FromFile("testdata/simple.xml"), WithReconnect(policy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cib.Close()
+	version, err := cib.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type received struct {
+		event CibEvent
+		diff  *CibDiff
+		doc   bool
+	}
+	events := make(chan received, 4)
+	_, err = cib.SubscribeDiff(func(event CibEvent, diff *CibDiff, doc *CibDocument) {
+		events <- received{event, diff, doc != nil && doc.Version() != nil}
+	}, WithDocument)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate pacemaker reporting the lost connection
+	destroyNotifyCallback(cib.cCib)
+
+	for _, expected := range []CibEvent{DisconnectedEvent, ReconnectedEvent} {
+		select {
+		case ev := <-events:
+			if ev.event != expected {
+				t.Fatalf("Expected %s, got %s", expected, ev.event)
+			}
+			if ev.event == ReconnectedEvent {
+				if ev.diff == nil || ev.diff.Source != *version || ev.diff.Target != *version {
+					t.Errorf("Expected no version gap, got %+v", ev.diff)
+				}
+				if !ev.doc {
+					t.Error("Expected the CIB with the ReconnectedEvent")
+				}
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %s", expected)
+		}
+	}
+	if !cib.ConnectionInfo().Connected {
+		t.Error("Expected connection to be signed on again")
+	}
+}
+
+func TestRemotePasswordNotKept(t *testing.T) {
+	cib, err := OpenCib(FromRemote("localhost", "hacluster", "secret", 3121, false), ForNoConnection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cib.Close()
+	if cib.config.passwd != "" {
+		t.Error("Expected password to be discarded after opening the connection")
+	}
+}
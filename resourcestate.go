@@ -0,0 +1,221 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The state of a resource on a node, as
+// derived from its operation history.
+type ResourceState int
+
+const (
+	StateUnknown ResourceState = iota
+	StateStopped
+	StateStarted
+	StatePromoted
+	StateUnpromoted
+	StateFailed
+)
+
+var resourceStateNames = []string{"Unknown", "Stopped", "Started", "Promoted", "Unpromoted", "Failed"}
+
+func (state ResourceState) String() string {
+	if state < 0 || int(state) >= len(resourceStateNames) {
+		return fmt.Sprintf("ResourceState(%d)", int(state))
+	}
+	return resourceStateNames[state]
+}
+
+// The computed state of a resource on a
+// single node. Instance is the id used in
+// the status section, which for clone
+// instances may carry a ":N" suffix that
+// Resource does not.
+type ResourceStatus struct {
+	Resource    string
+	Instance    string
+	Node        string
+	State       ResourceState
+	FailCount   Score
+	LastFailure *LrmRscOp
+}
+
+// Derives the state of every resource on
+// every node from the operation history in
+// the status section, using roughly the same
+// rules as crm_mon. Resources in the
+// configuration without any history are
+// reported once as stopped, with no node.
+func ComputeResourceStates(conf *Configuration, status *Status) []*ResourceStatus {
+	var result []*ResourceStatus
+	promotable := promotableResources(conf)
+	seen := make(map[string]bool)
+
+	for _, node := range status.Nodes {
+		for _, rsc := range node.Resources {
+			id := rsc.Id
+			if i := strings.IndexByte(id, ':'); i >= 0 {
+				id = id[:i]
+			}
+			seen[id] = true
+			st := computeResourceStatus(rsc)
+			st.Resource = id
+			st.Instance = rsc.Id
+			st.Node = node.Uname
+			st.FailCount = failCount(node, rsc.Id)
+			if st.State == StateStarted && promotable[id] {
+				st.State = StateUnpromoted
+			}
+			if !node.InCluster() && st.State != StateStopped {
+				st.State = StateUnknown
+			}
+			result = append(result, st)
+		}
+	}
+
+	if conf != nil {
+		for _, id := range primitiveIds(conf.Resources) {
+			if !seen[id] {
+				result = append(result, &ResourceStatus{Resource: id, Instance: id, State: StateStopped})
+			}
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Resource != result[j].Resource {
+			return result[i].Resource < result[j].Resource
+		}
+		return result[i].Node < result[j].Node
+	})
+	return result
+}
+
+func computeResourceStatus(rsc *LrmResource) *ResourceStatus {
+	st := &ResourceStatus{State: StateUnknown}
+
+	ops := make([]*LrmRscOp, 0, len(rsc.Operations))
+	for _, op := range rsc.Operations {
+		if op.OpStatus == OpPending || op.OpStatus == OpCancelled || op.CallId < 0 {
+			continue
+		}
+		ops = append(ops, op)
+	}
+	sort.SliceStable(ops, func(i, j int) bool {
+		return ops[i].CallId < ops[j].CallId
+	})
+
+	for _, op := range ops {
+		state, failed := opOutcome(op)
+		if failed {
+			st.State = StateFailed
+			if st.LastFailure == nil || op.LastRcChange >= st.LastFailure.LastRcChange {
+				st.LastFailure = op
+			}
+		} else if state != StateUnknown {
+			st.State = state
+		}
+	}
+	return st
+}
+
+// Returns the state implied by a single
+// operation, and whether it failed.
+func opOutcome(op *LrmRscOp) (ResourceState, bool) {
+	if op.OpStatus != OpDone {
+		return StateFailed, true
+	}
+	rc := op.RcCode
+	probe := op.Operation == "monitor" && op.Interval == 0
+
+	if op.Operation == "monitor" {
+		switch rc {
+		case OcfSuccess, OcfDegraded:
+			return StateStarted, false
+		case OcfRunningMaster, OcfDegradedMaster:
+			return StatePromoted, false
+		case OcfNotRunning:
+			if probe || op.TransitionKey.TargetRc == OcfNotRunning {
+				return StateStopped, false
+			}
+		}
+		return StateFailed, true
+	}
+
+	if rc != OcfSuccess {
+		return StateFailed, true
+	}
+	switch op.Operation {
+	case "start", "migrate_from":
+		return StateStarted, false
+	case "stop", "migrate_to":
+		return StateStopped, false
+	case "promote":
+		return StatePromoted, false
+	case "demote":
+		return StateStarted, false
+	}
+	return StateUnknown, false
+}
+
+// Sums the fail-count attributes recorded for
+// the resource on the node. Newer versions of
+// Pacemaker keep one fail count per operation,
+// named fail-count-<rsc>#<op>_<interval>.
+func failCount(node *NodeState, rsc string) Score {
+	var total Score
+	prefix := "fail-count-" + rsc
+	for _, set := range node.TransientAttributes {
+		for _, nv := range set.Nvpairs {
+			if nv.Name != prefix && !strings.HasPrefix(nv.Name, prefix+"#") {
+				continue
+			}
+			if n, err := ParseScore(nv.Value); err == nil {
+				total = total.Add(n)
+			}
+		}
+	}
+	return total
+}
+
+func promotableResources(conf *Configuration) map[string]bool {
+	result := make(map[string]bool)
+	if conf == nil {
+		return result
+	}
+	var walk func(resources []*Resource, promotable bool)
+	walk = func(resources []*Resource, promotable bool) {
+		for _, rsc := range resources {
+			p := promotable || rsc.Kind() == MasterResource || (rsc.Kind() == CloneResource && rsc.isPromotable())
+			if rsc.Kind() == PrimitiveResource && p {
+				result[rsc.Id] = true
+			}
+			walk(rsc.Children, p)
+		}
+	}
+	walk(conf.Resources, false)
+	return result
+}
+
+func (rsc *Resource) isPromotable() bool {
+	for _, set := range rsc.MetaAttributes {
+		if v, ok := set.Get("promotable"); ok {
+			return IsTrue(v)
+		}
+	}
+	return false
+}
+
+func primitiveIds(resources []*Resource) []string {
+	var ids []string
+	for _, rsc := range resources {
+		if rsc.Kind() == PrimitiveResource {
+			ids = append(ids, rsc.Id)
+		}
+		ids = append(ids, primitiveIds(rsc.Children)...)
+	}
+	return ids
+}
@@ -0,0 +1,71 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"testing"
+)
+
+func TestComputeResourceStates(t *testing.T) {
+	conf := loadConfiguration(t, "testdata/exit-reason.xml")
+	status := loadStatus(t, "testdata/exit-reason.xml")
+
+	states := make(map[string]*ResourceStatus)
+	for _, st := range ComputeResourceStates(conf, status) {
+		states[st.Resource+"@"+st.Node] = st
+	}
+
+	expected := map[string]ResourceState{
+		"gctvanas-vip@node1":  StateStarted,
+		"gctvanas-vip@node2":  StateStopped,
+		"gctvanas-fs1o@node1": StatePromoted,
+		"gctvanas-fs1o@node2": StateUnpromoted,
+		"gctvanas-lvm@node1":  StateStopped,
+		"gctvanas-lvm@node2":  StateStopped,
+	}
+	if len(states) != len(expected) {
+		t.Errorf("Expected %d states, got %d", len(expected), len(states))
+	}
+	for key, state := range expected {
+		st, ok := states[key]
+		if !ok {
+			t.Errorf("No state for %s", key)
+			continue
+		}
+		if st.State != state {
+			t.Errorf("Expected %s to be %s, got %s", key, state, st.State)
+		}
+	}
+
+	lvm := states["gctvanas-lvm@node1"]
+	if lvm.FailCount != ScoreInfinity {
+		t.Errorf("Expected INFINITY fail count, got %d", lvm.FailCount)
+	}
+	if lvm.LastFailure == nil || lvm.LastFailure.Operation != "start" || lvm.LastFailure.ExitReason != "LVM: targetfs did not activate correctly" {
+		t.Errorf("Unexpected last failure: %+v", lvm.LastFailure)
+	}
+	if states["gctvanas-fs1o@node1"].LastFailure != nil {
+		t.Errorf("Probe finding a promoted instance is not a failure")
+	}
+}
+
+func TestComputeResourceStatesNoHistory(t *testing.T) {
+	conf := loadConfiguration(t, "testdata/simple.xml")
+	status := loadStatus(t, "testdata/simple.xml")
+
+	states := ComputeResourceStates(conf, status)
+	if len(states) != 1 || states[0].Resource != "myAddr" || states[0].State != StateStopped || states[0].Node != "" {
+		t.Errorf("Unexpected states: %+v", states)
+	}
+}
+
+func TestFailedMonitor(t *testing.T) {
+	rsc := &LrmResource{Id: "dummy", Operations: []*LrmRscOp{
+		{Operation: "start", CallId: 1},
+		{Operation: "monitor", CallId: 2, Interval: 10000, RcCode: OcfNotRunning, ExitReason: "gone"},
+	}}
+	st := computeResourceStatus(rsc)
+	if st.State != StateFailed || st.LastFailure == nil || st.LastFailure.ExitReason != "gone" {
+		t.Errorf("Unexpected status: %+v", st)
+	}
+}
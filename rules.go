@@ -0,0 +1,522 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// What rules are evaluated against: the node,
+// the time, and the resource and operation the
+// rule applies to, if any.
+type RuleContext struct {
+	// Node attributes, including the built-in
+	// ones such as #uname, and #ra-version for
+	// versioned parameters.
+	NodeAttributes map[string]string
+	// The time to evaluate date expressions at.
+	// Defaults to the current time. Date specs
+	// are matched in the location of Now.
+	Now time.Time
+	// The agent of the resource, for
+	// rsc_expression.
+	ResourceClass    string
+	ResourceProvider string
+	ResourceType     string
+	// The role of the resource, for rules with
+	// a role.
+	Role string
+	// The operation, for op_expression.
+	OpName     string
+	OpInterval time.Duration
+	// Resource parameters and meta attributes,
+	// for expressions with a value-source of
+	// "param" or "meta".
+	ResourceParams map[string]string
+	ResourceMeta   map[string]string
+}
+
+// Returns the evaluation context for a node:
+// its attributes from the configuration and,
+// if status is not nil, its transient
+// attributes, plus the built-in attributes
+// #uname, #id, #kind, #cluster-name and
+// #site-name.
+func NewRuleContext(conf *Configuration, status *Status, node string, now time.Time) (*RuleContext, error) {
+	ctx := &RuleContext{NodeAttributes: make(map[string]string), Now: now}
+	attrs := ctx.NodeAttributes
+	if n := conf.FindNode(node); n != nil {
+		attrs["#uname"] = n.Uname
+		attrs["#id"] = n.Id
+		attrs["#kind"] = "cluster"
+		if n.Type == "remote" {
+			attrs["#kind"] = "remote"
+		}
+		values, err := EvaluateAttributeSets(n.InstanceAttributes, ctx)
+		if err != nil {
+			return nil, err
+		}
+		for name, nv := range values {
+			attrs[name] = nv.Value
+		}
+	} else {
+		attrs["#uname"] = node
+	}
+	if status != nil {
+		for _, ns := range status.Nodes {
+			if ns.Uname != attrs["#uname"] {
+				continue
+			}
+			if _, ok := attrs["#id"]; !ok {
+				attrs["#id"] = ns.Id
+			}
+			for _, set := range ns.TransientAttributes {
+				for _, nv := range set.Nvpairs {
+					attrs[nv.Name] = nv.Value
+				}
+			}
+		}
+	}
+	for _, set := range conf.CrmConfig {
+		if name, ok := set.Get("cluster-name"); ok {
+			attrs["#cluster-name"] = name
+		}
+	}
+	if site, ok := attrs["site-name"]; ok {
+		attrs["#site-name"] = site
+	} else if name, ok := attrs["#cluster-name"]; ok {
+		attrs["#site-name"] = name
+	}
+	return ctx, nil
+}
+
+func (ctx *RuleContext) now() time.Time {
+	if ctx.Now.IsZero() {
+		return time.Now()
+	}
+	return ctx.Now
+}
+
+// Evaluates the rule in the context. Rules
+// referring to another rule with id-ref must be
+// resolved by the caller.
+func (rule *Rule) Evaluate(ctx *RuleContext) (bool, error) {
+	if rule.IdRef != "" {
+		return false, &CibError{msg: "Cannot evaluate rule reference " + rule.IdRef}
+	}
+	if rule.Role != "" && !sameRole(rule.Role, ctx.Role) {
+		return false, nil
+	}
+	or := strings.EqualFold(rule.BooleanOp, "or")
+	// Combines the result of one expression,
+	// and reports whether the outcome is known.
+	combine := func(result bool) bool {
+		return result == or
+	}
+
+	for _, e := range rule.Expressions {
+		result, err := e.Evaluate(ctx)
+		if err != nil {
+			return false, err
+		}
+		if combine(result) {
+			return or, nil
+		}
+	}
+	for _, e := range rule.DateExpressions {
+		result, err := e.Evaluate(ctx)
+		if err != nil {
+			return false, err
+		}
+		if combine(result) {
+			return or, nil
+		}
+	}
+	for _, e := range rule.RscExpressions {
+		if combine(e.Evaluate(ctx)) {
+			return or, nil
+		}
+	}
+	for _, e := range rule.OpExpressions {
+		result, err := e.Evaluate(ctx)
+		if err != nil {
+			return false, err
+		}
+		if combine(result) {
+			return or, nil
+		}
+	}
+	for _, r := range rule.Rules {
+		result, err := r.Evaluate(ctx)
+		if err != nil {
+			return false, err
+		}
+		if combine(result) {
+			return or, nil
+		}
+	}
+	// As in Pacemaker, an empty "and" rule
+	// passes and an empty "or" rule does not.
+	return !or, nil
+}
+
+// Promoted and Unpromoted were called Master
+// and Slave before Pacemaker 2.1.
+func sameRole(a, b string) bool {
+	canonical := func(role string) string {
+		switch strings.ToLower(role) {
+		case "master":
+			return "promoted"
+		case "slave":
+			return "unpromoted"
+		}
+		return strings.ToLower(role)
+	}
+	return canonical(a) == canonical(b)
+}
+
+// Evaluates an expression on a node attribute.
+// The type defaults to comparing as numbers if
+// both sides are numbers and the operation is
+// an ordering, and as strings otherwise. String
+// comparison is case insensitive.
+func (e *Expression) Evaluate(ctx *RuleContext) (bool, error) {
+	actual, defined := ctx.NodeAttributes[e.Attribute]
+	switch strings.ToLower(e.Operation) {
+	case "defined":
+		return defined, nil
+	case "not_defined":
+		return !defined, nil
+	}
+
+	expected := e.Value
+	switch strings.ToLower(e.ValueSource) {
+	case "param":
+		expected = ctx.ResourceParams[e.Value]
+	case "meta":
+		expected = ctx.ResourceMeta[e.Value]
+	}
+
+	op := strings.ToLower(e.Operation)
+	switch op {
+	case "eq":
+		return defined && compareValues(actual, expected, e.Type, op) == 0, nil
+	case "ne":
+		return !defined || compareValues(actual, expected, e.Type, op) != 0, nil
+	case "lt", "lte", "gt", "gte":
+	default:
+		return false, &CibError{msg: "Unknown operation " + e.Operation + " in expression " + e.Id}
+	}
+	if !defined {
+		return false, nil
+	}
+	cmp := compareValues(actual, expected, e.Type, op)
+	switch op {
+	case "lt":
+		return cmp < 0, nil
+	case "lte":
+		return cmp <= 0, nil
+	case "gt":
+		return cmp > 0, nil
+	}
+	return cmp >= 0, nil
+}
+
+// Compares two values as the given type,
+// falling back to comparing them as strings if
+// either cannot be parsed.
+func compareValues(a, b, kind, op string) int {
+	kind = strings.ToLower(kind)
+	if kind == "" {
+		kind = "string"
+		if op != "eq" && op != "ne" {
+			if _, err := strconv.ParseFloat(a, 64); err == nil {
+				if _, err := strconv.ParseFloat(b, 64); err == nil {
+					kind = "number"
+				}
+			}
+		}
+	}
+	switch kind {
+	case "integer":
+		x, err1 := strconv.ParseInt(strings.TrimSpace(a), 10, 64)
+		y, err2 := strconv.ParseInt(strings.TrimSpace(b), 10, 64)
+		if err1 == nil && err2 == nil {
+			return compareOrdered(x < y, x > y)
+		}
+	case "number":
+		x, err1 := strconv.ParseFloat(strings.TrimSpace(a), 64)
+		y, err2 := strconv.ParseFloat(strings.TrimSpace(b), 64)
+		if err1 == nil && err2 == nil {
+			return compareOrdered(x < y, x > y)
+		}
+	case "version":
+		return compareVersions(a, b)
+	}
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
+
+func compareOrdered(less, greater bool) int {
+	if less {
+		return -1
+	} else if greater {
+		return 1
+	}
+	return 0
+}
+
+// Compares dotted version strings numerically,
+// component by component. A version with more
+// components is greater if all others match.
+func compareVersions(a, b string) int {
+	x := strings.Split(a, ".")
+	y := strings.Split(b, ".")
+	for i := 0; i < len(x) && i < len(y); i++ {
+		m, _ := strconv.Atoi(x[i])
+		n, _ := strconv.Atoi(y[i])
+		if m != n {
+			return compareOrdered(m < n, m > n)
+		}
+	}
+	return compareOrdered(len(x) < len(y), len(x) > len(y))
+}
+
+// Evaluates a date expression at ctx.Now.
+// Dates without an offset are in the location
+// of ctx.Now.
+func (e *DateExpression) Evaluate(ctx *RuleContext) (bool, error) {
+	now := ctx.now()
+	var start, end time.Time
+	var err error
+	if e.Start != "" {
+		if start, err = ParseDateTime(e.Start, now.Location()); err != nil {
+			return false, err
+		}
+	}
+	if e.End != "" {
+		if end, err = ParseDateTime(e.End, now.Location()); err != nil {
+			return false, err
+		}
+	} else if e.Duration != nil && !start.IsZero() {
+		end = e.Duration.Duration().AddTo(start)
+	}
+
+	switch strings.ToLower(e.Operation) {
+	case "in_range", "":
+		if start.IsZero() && end.IsZero() {
+			return false, &CibError{msg: "Date expression " + e.Id + " has no start or end"}
+		}
+		return (start.IsZero() || !now.Before(start)) && (end.IsZero() || !now.After(end)), nil
+	case "gt":
+		if start.IsZero() {
+			return false, &CibError{msg: "Date expression " + e.Id + " has no start"}
+		}
+		return now.After(start), nil
+	case "lt":
+		if end.IsZero() {
+			return false, &CibError{msg: "Date expression " + e.Id + " has no end"}
+		}
+		return now.Before(end), nil
+	case "date_spec":
+		if e.DateSpec == nil {
+			return false, &CibError{msg: "Date expression " + e.Id + " has no date_spec"}
+		}
+		return e.DateSpec.Matches(now)
+	}
+	return false, &CibError{msg: "Unknown operation " + e.Operation + " in date expression " + e.Id}
+}
+
+// Interprets the date_spec as the duration
+// element of an in_range date expression.
+func (spec *DateSpec) Duration() ISODuration {
+	n := func(s string) int {
+		v, _ := strconv.Atoi(s)
+		return v
+	}
+	return ISODuration{
+		Years:   n(spec.Years),
+		Months:  n(spec.Months),
+		Days:    n(spec.Weeks)*7 + n(spec.Days),
+		Hours:   n(spec.Hours),
+		Minutes: n(spec.Minutes),
+		Seconds: n(spec.Seconds),
+	}
+}
+
+// Reports whether the time matches every field
+// set in the date_spec. Weekdays run from 1 for
+// Monday to 7 for Sunday, and weeks and
+// weekyears follow ISO 8601.
+func (spec *DateSpec) Matches(t time.Time) (bool, error) {
+	weekyear, week := t.ISOWeek()
+	fields := []struct {
+		spec  string
+		value int
+	}{
+		{spec.Years, t.Year()},
+		{spec.Months, int(t.Month())},
+		{spec.Monthdays, t.Day()},
+		{spec.Hours, t.Hour()},
+		{spec.Minutes, t.Minute()},
+		{spec.Seconds, t.Second()},
+		{spec.Yeardays, t.YearDay()},
+		{spec.Weekyears, weekyear},
+		{spec.Weeks, week},
+		{spec.Weekdays, isoWeekday(t)},
+		{spec.Moon, moonPhase(t)},
+	}
+	for _, f := range fields {
+		if f.spec == "" {
+			continue
+		}
+		ok, err := inRange(f.spec, f.value)
+		if err != nil {
+			return false, &CibError{msg: "Invalid range " + f.spec + " in date_spec " + spec.Id}
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Checks a value against a single number or an
+// inclusive range such as "1-5". Either end of a
+// range may be left open, as in "9-" or "-5".
+func inRange(spec string, value int) (bool, error) {
+	parts := strings.SplitN(strings.TrimSpace(spec), "-", 2)
+	bound := func(s string, open int) (int, error) {
+		s = strings.TrimSpace(s)
+		if s == "" && len(parts) == 2 {
+			return open, nil
+		}
+		return strconv.Atoi(s)
+	}
+	low, err := bound(parts[0], math.MinInt32)
+	if err != nil {
+		return false, err
+	}
+	high := low
+	if len(parts) == 2 {
+		if high, err = bound(parts[1], math.MaxInt32); err != nil {
+			return false, err
+		}
+		if parts[0] == "" && parts[1] == "" {
+			return false, strconv.ErrSyntax
+		}
+	}
+	return value >= low && value <= high, nil
+}
+
+// The phase of the moon from 0 (new) to 7, as
+// Pacemaker computes it for the deprecated moon
+// field of date_spec.
+func moonPhase(t time.Time) int {
+	goldn := t.Year()%19 + 1
+	epact := (11*goldn + 18) % 30
+	if (epact == 25 && goldn > 11) || epact == 24 {
+		epact++
+	}
+	return ((((t.YearDay() + epact) * 6) + 11) % 177 / 22) & 7
+}
+
+// Matches the agent of the resource in the
+// context. Attributes that are not set match
+// any agent.
+func (e *RscExpression) Evaluate(ctx *RuleContext) bool {
+	return (e.Class == "" || e.Class == ctx.ResourceClass) &&
+		(e.Provider == "" || e.Provider == ctx.ResourceProvider) &&
+		(e.Type == "" || e.Type == ctx.ResourceType)
+}
+
+// Matches the operation in the context. An
+// expression without an interval matches any
+// interval.
+func (e *OpExpression) Evaluate(ctx *RuleContext) (bool, error) {
+	if ctx.OpName == "" || e.Name != ctx.OpName {
+		return false, nil
+	}
+	if e.Interval == "" {
+		return true, nil
+	}
+	interval, err := ParseInterval(e.Interval)
+	if err != nil {
+		return false, err
+	}
+	return interval == ctx.OpInterval, nil
+}
+
+// Returns the nvpairs that apply in the context,
+// keyed by name. Sets with rules of which none
+// passes are skipped. The remaining sets are applied in
+// order of decreasing score, and the first set
+// to define a name supplies its value, as
+// Pacemaker does for resource attributes.
+func EvaluateAttributeSets(sets []*AttributeSet, ctx *RuleContext) (map[string]*Nvpair, error) {
+	return evaluateAttributeSets(sets, ctx, "")
+}
+
+// As EvaluateAttributeSets, but the set with
+// the id first is applied before all others
+// regardless of score, as Pacemaker does for
+// cib-bootstrap-options.
+func evaluateAttributeSets(sets []*AttributeSet, ctx *RuleContext, first string) (map[string]*Nvpair, error) {
+	var applied []*AttributeSet
+	for _, set := range sets {
+		// As in Pacemaker's pe_evaluate_rules, a set
+		// applies if any of its rules passes.
+		ok := len(set.Rules) == 0
+		for _, rule := range set.Rules {
+			passed, err := rule.Evaluate(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if passed {
+				ok = true
+				break
+			}
+		}
+		if ok {
+			applied = append(applied, set)
+		}
+	}
+	var firstSets, others []*AttributeSet
+	for _, set := range applied {
+		if first != "" && set.Id == first {
+			firstSets = append(firstSets, set)
+		} else {
+			others = append(others, set)
+		}
+	}
+	sort.SliceStable(others, func(i, j int) bool { return others[i].Score > others[j].Score })
+	applied = append(firstSets, others...)
+
+	result := make(map[string]*Nvpair)
+	for _, set := range applied {
+		for _, nv := range set.Nvpairs {
+			if _, ok := result[nv.Name]; !ok {
+				result[nv.Name] = nv
+			}
+		}
+	}
+	return result, nil
+}
+
+// Returns the instance attributes of the
+// resource that apply in the context, which
+// is typically created by NewRuleContext for
+// the node of interest. The agent of the
+// resource is filled in from the configuration.
+func (conf *Configuration) ResourceParameters(id string, ctx *RuleContext) (map[string]*Nvpair, error) {
+	rsc := conf.FindResource(id)
+	if rsc == nil {
+		return nil, &CibError{msg: "No resource named " + id}
+	}
+	rctx := *ctx
+	rctx.ResourceClass, rctx.ResourceProvider, rctx.ResourceType = rsc.Class, rsc.Provider, rsc.Type
+	return EvaluateAttributeSets(rsc.InstanceAttributes, &rctx)
+}
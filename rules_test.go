@@ -0,0 +1,211 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEvaluateVersionedParameters(t *testing.T) {
+	conf := loadConfiguration(t, "testdata/versioned-resources.xml")
+	tests := []struct {
+		attrs    map[string]string
+		expected map[string]string
+	}{
+		{
+			map[string]string{"#ra-version": "1.1"},
+			map[string]string{"new_fake": "new+false", "envfile": "/run/resource-agents/vtest4.env"},
+		},
+		{
+			map[string]string{"#ra-version": "1.1", "myattr": "TRUE"},
+			map[string]string{"new_fake": "new+true", "envfile": "/run/resource-agents/vtest4.env"},
+		},
+		{
+			map[string]string{"#ra-version": "1.0", "myattr": "true"},
+			map[string]string{"fake": "old+true", "envfile": "/run/resource-agents/vtest4.env"},
+		},
+		{
+			map[string]string{},
+			map[string]string{"envfile": "/run/resource-agents/vtest4.env"},
+		},
+	}
+	for _, test := range tests {
+		params, err := conf.ResourceParameters("vtest4", &RuleContext{NodeAttributes: test.attrs})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(params) != len(test.expected) {
+			t.Errorf("%v: expected %d parameters, got %d", test.attrs, len(test.expected), len(params))
+		}
+		for name, value := range test.expected {
+			if nv := params[name]; nv == nil || nv.Value != value {
+				t.Errorf("%v: expected %s=%s, got %+v", test.attrs, name, value, nv)
+			}
+		}
+	}
+
+	if _, err := conf.ResourceParameters("missing", &RuleContext{}); err == nil {
+		t.Error("Expected error for unknown resource")
+	}
+}
+
+func TestEvaluateSetScores(t *testing.T) {
+	sets := []*AttributeSet{
+		{Id: "low", Score: 1, Nvpairs: []*Nvpair{{Id: "low-a", Name: "a", Value: "1"}, {Id: "low-b", Name: "b", Value: "1"}}},
+		{Id: "high", Score: ScoreInfinity, Nvpairs: []*Nvpair{{Id: "high-a", Name: "a", Value: "2"}}},
+		{Id: "unscored", Nvpairs: []*Nvpair{{Id: "unscored-b", Name: "b", Value: "3"}}},
+	}
+	values, err := EvaluateAttributeSets(sets, &RuleContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["a"].Id != "high-a" || values["b"].Id != "low-b" {
+		t.Errorf("Unexpected values: a=%+v b=%+v", values["a"], values["b"])
+	}
+	values, err = evaluateAttributeSets(sets, &RuleContext{}, "unscored")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["b"].Id != "unscored-b" {
+		t.Errorf("Expected first set to win, got %+v", values["b"])
+	}
+}
+
+func TestEvaluateSetRules(t *testing.T) {
+	ctx := &RuleContext{NodeAttributes: map[string]string{"#uname": "node1"}}
+	match := &Rule{Expressions: []*Expression{{Attribute: "#uname", Operation: "eq", Value: "node1"}}}
+	other := &Rule{Expressions: []*Expression{{Attribute: "#uname", Operation: "eq", Value: "node2"}}}
+	sets := []*AttributeSet{
+		{Id: "any", Rules: []*Rule{other, match}, Nvpairs: []*Nvpair{{Id: "any-a", Name: "a", Value: "1"}}},
+		{Id: "none", Rules: []*Rule{other}, Nvpairs: []*Nvpair{{Id: "none-b", Name: "b", Value: "2"}}},
+	}
+	values, err := EvaluateAttributeSets(sets, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["a"] == nil || values["b"] != nil {
+		t.Errorf("Expected a set to apply if any of its rules passes, got %v", values)
+	}
+}
+
+func TestEvaluateExpressions(t *testing.T) {
+	ctx := &RuleContext{
+		NodeAttributes: map[string]string{"#uname": "node1", "memory": "1024", "site": "Paris"},
+		ResourceParams: map[string]string{"min-memory": "2048"},
+	}
+	tests := []struct {
+		expr     Expression
+		expected bool
+	}{
+		{Expression{Attribute: "#uname", Operation: "eq", Value: "NODE1"}, true},
+		{Expression{Attribute: "memory", Operation: "gt", Value: "512"}, true},
+		{Expression{Attribute: "memory", Operation: "gt", Value: "512", Type: "string"}, false},
+		{Expression{Attribute: "memory", Operation: "gte", Value: "1024", Type: "integer"}, true},
+		{Expression{Attribute: "memory", Operation: "lt", Value: "min-memory", ValueSource: "param"}, true},
+		{Expression{Attribute: "site", Operation: "defined"}, true},
+		{Expression{Attribute: "rack", Operation: "not_defined"}, true},
+		{Expression{Attribute: "rack", Operation: "ne", Value: "1"}, true},
+		{Expression{Attribute: "rack", Operation: "lt", Value: "1"}, false},
+		{Expression{Attribute: "rack", Operation: "eq", Value: ""}, false},
+	}
+	for _, test := range tests {
+		got, err := test.expr.Evaluate(ctx)
+		if err != nil {
+			t.Errorf("%+v: %v", test.expr, err)
+		} else if got != test.expected {
+			t.Errorf("%+v: expected %v", test.expr, test.expected)
+		}
+	}
+	if _, err := (&Expression{Attribute: "site", Operation: "like"}).Evaluate(ctx); err == nil {
+		t.Error("Expected error for unknown operation")
+	}
+
+	if compareVersions("1.2.0", "1.10") >= 0 || compareVersions("1.2", "1.2.0") >= 0 || compareVersions("2", "1.9") <= 0 {
+		t.Error("Unexpected version ordering")
+	}
+}
+
+func TestEvaluateRules(t *testing.T) {
+	// A Friday afternoon.
+	now := time.Date(2019, 3, 1, 15, 30, 0, 0, time.UTC)
+	ctx := &RuleContext{
+		NodeAttributes:   map[string]string{"#uname": "node1"},
+		Now:              now,
+		ResourceClass:    "ocf",
+		ResourceProvider: "heartbeat",
+		ResourceType:     "IPaddr2",
+		OpName:           "monitor",
+		OpInterval:       10 * time.Second,
+		Role:             "Promoted",
+	}
+	workHours := &DateSpec{Id: "work", Hours: "9-16", Weekdays: "1-5"}
+	tests := []struct {
+		name     string
+		rule     Rule
+		expected bool
+	}{
+		{"empty", Rule{}, true},
+		{"empty or", Rule{BooleanOp: "or"}, false},
+		{"in range", Rule{DateExpressions: []*DateExpression{{Operation: "in_range", Start: "2019-01-01", End: "2019-12-31"}}}, true},
+		{"in range duration", Rule{DateExpressions: []*DateExpression{{Operation: "in_range", Start: "2019-02-01", Duration: &DateSpec{Months: "2"}}}}, true},
+		{"expired duration", Rule{DateExpressions: []*DateExpression{{Operation: "in_range", Start: "2019-02-01", Duration: &DateSpec{Days: "27"}}}}, false},
+		{"gt", Rule{DateExpressions: []*DateExpression{{Operation: "gt", Start: "2019-03-01 15:00:00"}}}, true},
+		{"lt", Rule{DateExpressions: []*DateExpression{{Operation: "lt", End: "2019-03-01T15:00:00Z"}}}, false},
+		{"date spec", Rule{DateExpressions: []*DateExpression{{Operation: "date_spec", DateSpec: workHours}}}, true},
+		{"weekend", Rule{DateExpressions: []*DateExpression{{Operation: "date_spec", DateSpec: &DateSpec{Weekdays: "6-7"}}}}, false},
+		{"open range", Rule{DateExpressions: []*DateExpression{{Operation: "date_spec", DateSpec: &DateSpec{Hours: "9-", Weekdays: "-5"}}}}, true},
+		{"closed open range", Rule{DateExpressions: []*DateExpression{{Operation: "date_spec", DateSpec: &DateSpec{Hours: "-14"}}}}, false},
+		{"rsc", Rule{RscExpressions: []*RscExpression{{Class: "ocf", Type: "IPaddr2"}}}, true},
+		{"other rsc", Rule{RscExpressions: []*RscExpression{{Provider: "pacemaker"}}}, false},
+		{"op", Rule{OpExpressions: []*OpExpression{{Name: "monitor", Interval: "10s"}}}, true},
+		{"other interval", Rule{OpExpressions: []*OpExpression{{Name: "monitor", Interval: "PT20S"}}}, false},
+		{"and", Rule{
+			Expressions:    []*Expression{{Attribute: "#uname", Operation: "eq", Value: "node1"}},
+			RscExpressions: []*RscExpression{{Provider: "pacemaker"}},
+		}, false},
+		{"or", Rule{
+			BooleanOp:      "or",
+			Expressions:    []*Expression{{Attribute: "#uname", Operation: "eq", Value: "node2"}},
+			RscExpressions: []*RscExpression{{Class: "ocf"}},
+		}, true},
+		{"nested", Rule{Rules: []*Rule{{BooleanOp: "or", Expressions: []*Expression{{Attribute: "#uname", Operation: "eq", Value: "node2"}}}}}, false},
+		{"legacy role", Rule{Role: "Master"}, true},
+		{"other role", Rule{Role: "Unpromoted"}, false},
+	}
+	for _, test := range tests {
+		got, err := test.rule.Evaluate(ctx)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if got != test.expected {
+			t.Errorf("%s: expected %v", test.name, test.expected)
+		}
+	}
+
+	if _, err := (&Rule{IdRef: "other"}).Evaluate(ctx); err == nil {
+		t.Error("Expected error for rule reference")
+	}
+	if _, err := (&DateExpression{Id: "bad", Operation: "in_range", Start: "soon"}).Evaluate(ctx); err == nil {
+		t.Error("Expected error for invalid date")
+	}
+}
+
+func TestNewRuleContext(t *testing.T) {
+	conf := loadConfiguration(t, "testdata/exit-reason.xml")
+	status := loadStatus(t, "testdata/exit-reason.xml")
+	ctx, err := NewRuleContext(conf, status, "node1", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"#uname":               "node1",
+		"#id":                  "node1",
+		"#kind":                "cluster",
+		"master-gctvanas-fs1o": "10000",
+	}
+	for name, value := range expected {
+		if ctx.NodeAttributes[name] != value {
+			t.Errorf("Expected %s=%s, got %q", name, value, ctx.NodeAttributes[name])
+		}
+	}
+}
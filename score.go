@@ -0,0 +1,105 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"strconv"
+)
+
+// A Pacemaker score, as used in constraints,
+// attribute sets and by the scheduler. Scores
+// are limited to the range -INFINITY to
+// INFINITY, and both limits absorb any
+// value added to them.
+//
+// Scores encode as "INFINITY", "-INFINITY" or
+// a decimal integer in both XML and JSON.
+type Score int
+
+const (
+	// Pacemaker's representation of INFINITY.
+	ScoreInfinity      Score = 1000000
+	ScoreMinusInfinity Score = -ScoreInfinity
+)
+
+// Parses a score. Besides integers, Pacemaker
+// accepts "INFINITY", "+INFINITY" and
+// "-INFINITY", and the node health colors
+// "red", "yellow" and "green". The colors are
+// given the default values of the
+// node-health-red, node-health-yellow and
+// node-health-green cluster options. Integers
+// beyond INFINITY are clamped.
+func ParseScore(s string) (Score, error) {
+	switch s {
+	case "INFINITY", "+INFINITY":
+		return ScoreInfinity, nil
+	case "-INFINITY", "red":
+		return ScoreMinusInfinity, nil
+	case "yellow", "green":
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		if ne, ok := err.(*strconv.NumError); !ok || ne.Err != strconv.ErrRange {
+			return 0, &CibError{msg: "Invalid score: " + s}
+		}
+	}
+	return clampScore(n), nil
+}
+
+func clampScore(n int64) Score {
+	if n >= int64(ScoreInfinity) {
+		return ScoreInfinity
+	} else if n <= int64(ScoreMinusInfinity) {
+		return ScoreMinusInfinity
+	}
+	return Score(n)
+}
+
+// Adds two scores as Pacemaker does: -INFINITY
+// plus anything is -INFINITY, INFINITY plus
+// anything else is INFINITY, and other sums
+// are clamped to the range of scores.
+func (s Score) Add(other Score) Score {
+	if s <= ScoreMinusInfinity || other <= ScoreMinusInfinity {
+		return ScoreMinusInfinity
+	} else if s >= ScoreInfinity || other >= ScoreInfinity {
+		return ScoreInfinity
+	}
+	return clampScore(int64(s) + int64(other))
+}
+
+// Returns true for INFINITY and -INFINITY.
+func (s Score) IsInfinite() bool {
+	return s >= ScoreInfinity || s <= ScoreMinusInfinity
+}
+
+func (s Score) String() string {
+	switch {
+	case s >= ScoreInfinity:
+		return "INFINITY"
+	case s <= ScoreMinusInfinity:
+		return "-INFINITY"
+	}
+	return strconv.Itoa(int(s))
+}
+
+func (s Score) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Score) UnmarshalText(text []byte) error {
+	n, err := ParseScore(string(text))
+	if err != nil {
+		return err
+	}
+	*s = n
+	return nil
+}
+
+// Returns a pointer to the score, for the
+// optional scores of constraints.
+func ScoreOf(s Score) *Score {
+	return &s
+}
@@ -0,0 +1,109 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+)
+
+func TestParseScore(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected Score
+	}{
+		{"INFINITY", ScoreInfinity},
+		{"+INFINITY", ScoreInfinity},
+		{"-INFINITY", ScoreMinusInfinity},
+		{"red", ScoreMinusInfinity},
+		{"yellow", 0},
+		{"green", 0},
+		{"100", 100},
+		{"-5", -5},
+		{"+7", 7},
+		{"2000000", ScoreInfinity},
+		{"-99999999999999999999", ScoreMinusInfinity},
+	}
+	for _, test := range tests {
+		got, err := ParseScore(test.input)
+		if err != nil {
+			t.Errorf("%s: %v", test.input, err)
+		} else if got != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.input, test.expected, got)
+		}
+	}
+	for _, input := range []string{"", "infinity", "1.5", "ten"} {
+		if _, err := ParseScore(input); err == nil {
+			t.Errorf("Expected error for %q", input)
+		}
+	}
+}
+
+func TestScoreAdd(t *testing.T) {
+	tests := []struct {
+		a, b, expected Score
+	}{
+		{1, 2, 3},
+		{ScoreInfinity, -100, ScoreInfinity},
+		{ScoreMinusInfinity, ScoreInfinity, ScoreMinusInfinity},
+		{ScoreInfinity, ScoreMinusInfinity, ScoreMinusInfinity},
+		{999999, 999999, ScoreInfinity},
+		{-999999, -2, ScoreMinusInfinity},
+	}
+	for _, test := range tests {
+		if got := test.a.Add(test.b); got != test.expected {
+			t.Errorf("%v + %v: expected %v, got %v", test.a, test.b, test.expected, got)
+		}
+	}
+	if ScoreInfinity.String() != "INFINITY" || ScoreMinusInfinity.String() != "-INFINITY" || Score(-3).String() != "-3" {
+		t.Error("Unexpected score formatting")
+	}
+	if !ScoreMinusInfinity.IsInfinite() || Score(10).IsInfinite() {
+		t.Error("Unexpected IsInfinite")
+	}
+}
+
+func TestScoreEncoding(t *testing.T) {
+	data := `<rsc_colocation id="c" rsc="a" with-rsc="b" score="0"></rsc_colocation>`
+	var c ColocationConstraint
+	if err := xml.Unmarshal([]byte(data), &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Score == nil || *c.Score != 0 {
+		t.Fatalf("Expected score 0, got %v", c.Score)
+	}
+	out, err := marshalElement("rsc_colocation", &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != data {
+		t.Errorf("Unexpected XML: %s", out)
+	}
+
+	var o OrderConstraint
+	if err := xml.Unmarshal([]byte(`<rsc_order id="o" first="a" then="b"/>`), &o); err != nil {
+		t.Fatal(err)
+	}
+	if o.Score != nil {
+		t.Errorf("Expected no score, got %v", *o.Score)
+	}
+	if err := xml.Unmarshal([]byte(`<rsc_order id="o" first="a" then="b" score="lots"/>`), &o); err == nil {
+		t.Error("Expected error for invalid score")
+	}
+
+	j, err := json.Marshal(&LocationConstraint{Id: "l", Rsc: "a", Node: "n", Score: ScoreOf(ScoreMinusInfinity)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(j) != `{"id":"l","rsc":"a","score":"-INFINITY","node":"n"}` {
+		t.Errorf("Unexpected JSON: %s", j)
+	}
+	var l LocationConstraint
+	if err := json.Unmarshal(j, &l); err != nil {
+		t.Fatal(err)
+	}
+	if l.Score == nil || *l.Score != ScoreMinusInfinity {
+		t.Errorf("Expected -INFINITY, got %v", l.Score)
+	}
+}
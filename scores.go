@@ -0,0 +1,494 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ClusterLabs/go-pacemaker/cibxml"
+)
+
+// A score the scheduler assigned to running a
+// resource on a node, as shown by
+// crm_simulate -s. The scheduler reports
+// scores at several steps while placing a
+// resource; Function is the step that
+// reported this one.
+type AllocationScore struct {
+	// For a clone instance, Instance is the id
+	// with the ":N" suffix, and Resource the id
+	// without.
+	Resource string
+	Instance string
+	Node     string
+	Score    Score
+	Function string
+}
+
+// The promotion score of an instance of a
+// promotable clone, and the node it was
+// placed on.
+type PromotionScore struct {
+	Resource string
+	Instance string
+	Node     string
+	Score    Score
+}
+
+// All scores the scheduler computed for a CIB,
+// in the order they were reported.
+type AllocationScores struct {
+	Allocations []*AllocationScore
+	Promotions  []*PromotionScore
+}
+
+// Returns the final allocation score of the
+// resource on each node. The last score
+// reported for each node is the final one.
+// Given the id of a cloned resource, the
+// highest score of any of its instances is
+// returned.
+func (scores *AllocationScores) Final(resource string) map[string]Score {
+	last := make(map[string]map[string]Score)
+	for _, s := range scores.Allocations {
+		if s.Instance != resource && s.Resource != resource {
+			continue
+		}
+		if last[s.Instance] == nil {
+			last[s.Instance] = make(map[string]Score)
+		}
+		last[s.Instance][s.Node] = s.Score
+	}
+	result := make(map[string]Score)
+	for _, nodes := range last {
+		for node, score := range nodes {
+			if prev, ok := result[node]; !ok || score > prev {
+				result[node] = score
+			}
+		}
+	}
+	return result
+}
+
+// Returns the promotion scores of the
+// instances of the resource.
+func (scores *AllocationScores) Promotion(resource string) []*PromotionScore {
+	var result []*PromotionScore
+	for _, s := range scores.Promotions {
+		if s.Instance == resource || s.Resource == resource {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// Parses the scores from the XML output of
+// the scheduler. Scores may be nested at any
+// depth in the output.
+func parseAllocationScores(data string) (*AllocationScores, error) {
+	root, err := cibxml.ParseString(data)
+	if err != nil {
+		return nil, &CibError{Operation: "simulate", msg: "Failed to parse scores: " + err.Error()}
+	}
+	scores := &AllocationScores{}
+	root.Walk(func(e *cibxml.Element) bool {
+		switch e.Name {
+		case "node_weight":
+			score, err := ParseScore(e.Get("score"))
+			if err != nil {
+				return true
+			}
+			scores.Allocations = append(scores.Allocations, &AllocationScore{
+				Resource: baseResourceId(e.Get("id")),
+				Instance: e.Get("id"),
+				Node:     e.Get("node"),
+				Score:    score,
+				Function: e.Get("function"),
+			})
+		case "promotion_score":
+			score, err := ParseScore(e.Get("score"))
+			if err != nil {
+				return true
+			}
+			scores.Promotions = append(scores.Promotions, &PromotionScore{
+				Resource: baseResourceId(e.Get("id")),
+				Instance: e.Get("id"),
+				Node:     e.Get("node"),
+				Score:    score,
+			})
+		}
+		return true
+	})
+	return scores, nil
+}
+
+func baseResourceId(id string) string {
+	if i := strings.IndexByte(id, ':'); i >= 0 {
+		return id[:i]
+	}
+	return id
+}
+
+// What a contribution to a score comes from.
+type ContributionKind string
+
+const (
+	// A location constraint, or one of its rules.
+	LocationContribution ContributionKind = "location"
+	// A colocation constraint, applied to the
+	// nodes the other resource is active on.
+	ColocationContribution ContributionKind = "colocation"
+	// The resource-stickiness of a resource on
+	// the nodes it is active on.
+	StickinessContribution ContributionKind = "stickiness"
+	// A ban from a node after the fail count
+	// reached the migration-threshold.
+	FailureContribution ContributionKind = "failure"
+	// A promotion preference set by the
+	// resource agent with crm_master.
+	PromotionContribution ContributionKind = "promotion"
+)
+
+// A single input to the placement of a
+// resource. Id is the id of the constraint,
+// rule, attribute set or node attribute the
+// score comes from. Node is empty if it is
+// not known which nodes the score applies
+// to, as for a colocation with a resource
+// that is not active.
+type ScoreContribution struct {
+	Kind  ContributionKind
+	Id    string
+	Node  string
+	Score Score
+}
+
+// An explanation of where the scheduler would
+// place a resource, and why.
+type Placement struct {
+	Resource string
+	// Final allocation score on each node.
+	Scores map[string]Score
+	// Promotion scores of each instance, for
+	// promotable clones.
+	Promotions []*PromotionScore
+	// The configuration and state contributing
+	// to the scores.
+	Contributions []*ScoreContribution
+}
+
+// Returns the nodes with the highest score,
+// sorted by name. Nodes with a negative score
+// cannot run the resource and are never
+// preferred.
+func (p *Placement) Preferred() []string {
+	var nodes []string
+	var best Score
+	for node, score := range p.Scores {
+		if score < 0 {
+			continue
+		}
+		if len(nodes) == 0 || score > best {
+			nodes = []string{node}
+			best = score
+		} else if score == best {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// Combines the scores computed by the scheduler
+// for a resource with the constraints,
+// stickiness and failures that contributed to
+// them, found in the configuration and status
+// of the same CIB. Constraints and meta
+// attributes of the groups and clones the
+// resource is part of are included. Colocation
+// sets are expanded into the colocations the
+// scheduler creates for them, and a colocation
+// also contributes to its with-rsc, on the
+// nodes the dependent resource is active on,
+// unless its influence is false.
+func ExplainPlacement(conf *Configuration, status *Status, scores *AllocationScores, resource string) *Placement {
+	p := &Placement{
+		Resource:   resource,
+		Scores:     scores.Final(resource),
+		Promotions: scores.Promotion(resource),
+	}
+	path := resourcePath(conf.Resources, resource)
+	if len(path) == 0 {
+		return p
+	}
+	ids := make(map[string]bool)
+	for _, rsc := range path {
+		ids[rsc.Id] = true
+	}
+	states := ComputeResourceStates(conf, status)
+	active := func(ids map[string]bool, promoted bool) []string {
+		var nodes []string
+		for _, st := range states {
+			if st.Node == "" || !(ids[st.Resource] || ids[st.Instance]) {
+				continue
+			}
+			if st.State == StatePromoted || (!promoted && (st.State == StateStarted || st.State == StateUnpromoted)) {
+				nodes = append(nodes, st.Node)
+			}
+		}
+		return nodes
+	}
+	add := func(kind ContributionKind, id, node string, score Score) {
+		p.Contributions = append(p.Contributions, &ScoreContribution{kind, id, node, score})
+	}
+	addValue := func(kind ContributionKind, id, node, value string) {
+		if score, err := ParseScore(value); err == nil {
+			add(kind, id, node, score)
+		}
+	}
+
+	for _, c := range conf.Constraints.Locations {
+		if !ids[c.Rsc] && !matchesPattern(c.RscPattern, ids) && !inResourceSets(c.ResourceSets, ids) {
+			continue
+		}
+		if c.Node != "" && c.Score != nil {
+			add(LocationContribution, c.Id, c.Node, *c.Score)
+		}
+		for _, rule := range c.Rules {
+			// A rule contributes on each node it
+			// passes on. With a score-attribute,
+			// the score is the value of the node
+			// attribute, and nodes without the
+			// attribute are skipped, as the
+			// scheduler skips them.
+			for _, node := range nodeNames(conf, status) {
+				ctx, err := NewRuleContext(conf, status, node, time.Time{})
+				if err != nil {
+					continue
+				}
+				if ok, err := rule.Evaluate(ctx); err != nil || !ok {
+					continue
+				}
+				if rule.ScoreAttribute == "" {
+					add(LocationContribution, rule.Id, node, rule.Score)
+				} else if value, ok := ctx.NodeAttributes[rule.ScoreAttribute]; ok {
+					addValue(LocationContribution, rule.Id, node, value)
+				}
+			}
+		}
+	}
+
+	for _, c := range colocationPairs(conf.Constraints.Colocations) {
+		if c.score == nil {
+			continue
+		}
+		var nodes []string
+		if ids[c.rsc] {
+			nodes = append(nodes, active(map[string]bool{c.withRsc: true}, sameRole(c.withRscRole, "Promoted"))...)
+			if len(nodes) == 0 {
+				nodes = []string{""}
+			}
+		}
+		if ids[c.withRsc] && c.influence {
+			dependent := active(map[string]bool{c.rsc: true}, sameRole(c.rscRole, "Promoted"))
+			if len(dependent) == 0 {
+				dependent = []string{""}
+			}
+			nodes = append(nodes, dependent...)
+		}
+		for _, node := range nodes {
+			add(ColocationContribution, c.id, node, *c.score)
+		}
+	}
+
+	if stickiness, id, ok := metaAttribute(conf, path, "resource-stickiness"); ok {
+		for _, node := range active(ids, false) {
+			addValue(StickinessContribution, id, node, stickiness)
+		}
+	}
+
+	threshold := ScoreInfinity
+	if value, _, ok := metaAttribute(conf, path, "migration-threshold"); ok {
+		if n, err := ParseScore(value); err == nil {
+			threshold = n
+		}
+	}
+	for _, st := range states {
+		if ids[st.Resource] && threshold > 0 && st.FailCount >= threshold {
+			add(FailureContribution, st.Instance, st.Node, ScoreMinusInfinity)
+		}
+	}
+
+	if promotableResources(conf)[resource] {
+		for _, node := range status.Nodes {
+			for _, set := range node.TransientAttributes {
+				for _, nv := range set.Nvpairs {
+					if nv.Name == "master-"+resource || strings.HasPrefix(nv.Name, "master-"+resource+":") {
+						addValue(PromotionContribution, nv.Name, node.Uname, nv.Value)
+					}
+				}
+			}
+		}
+	}
+	return p
+}
+
+// Returns the resource with the given id and
+// the groups, clones and bundles containing it,
+// outermost first.
+func resourcePath(resources []*Resource, id string) []*Resource {
+	for _, rsc := range resources {
+		if rsc.Id == id {
+			return []*Resource{rsc}
+		}
+		if path := resourcePath(rsc.Children, id); path != nil {
+			return append([]*Resource{rsc}, path...)
+		}
+	}
+	return nil
+}
+
+// Looks up a meta attribute of the innermost
+// resource in the path that sets it, falling
+// back to the resource defaults. Returns the id
+// of the attribute set it was found in.
+func metaAttribute(conf *Configuration, path []*Resource, name string) (string, string, bool) {
+	for i := len(path) - 1; i >= 0; i-- {
+		for _, set := range path[i].MetaAttributes {
+			if v, ok := set.Get(name); ok {
+				return v, set.Id, true
+			}
+		}
+	}
+	for _, set := range conf.RscDefaults {
+		if v, ok := set.Get(name); ok {
+			return v, set.Id, true
+		}
+	}
+	return "", "", false
+}
+
+// Reports whether any of the resources is
+// referred to by the resource sets.
+func inResourceSets(sets []*ResourceSet, ids map[string]bool) bool {
+	for _, set := range sets {
+		for _, ref := range set.Resources {
+			if ids[ref.Id] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the names of the nodes in the
+// configuration, followed by those only found
+// in the status, such as remote nodes.
+func nodeNames(conf *Configuration, status *Status) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, node := range conf.Nodes {
+		names = append(names, node.Uname)
+		seen[node.Uname] = true
+	}
+	if status != nil {
+		for _, node := range status.Nodes {
+			if !seen[node.Uname] {
+				names = append(names, node.Uname)
+				seen[node.Uname] = true
+			}
+		}
+	}
+	return names
+}
+
+// A colocation of the resource rsc with the
+// resource withRsc.
+type colocationPair struct {
+	id          string
+	rsc         string
+	withRsc     string
+	rscRole     string
+	withRscRole string
+	score       *Score
+	influence   bool
+}
+
+// Returns the colocations between two
+// resources the constraints amount to. As in
+// the scheduler, each resource of a sequential
+// set is colocated with the one before it, the
+// resources of a non-sequential set with a
+// negative score with each other, and each set
+// with the set after it: the first resource of
+// a sequential set or every resource of a
+// non-sequential one, with the last resource
+// or every resource of the next set.
+func colocationPairs(constraints []*ColocationConstraint) []*colocationPair {
+	var pairs []*colocationPair
+	for _, c := range constraints {
+		influence := c.Influence == "" || IsTrue(c.Influence)
+		pair := func(rsc, withRsc, rscRole, withRscRole string, score *Score) {
+			pairs = append(pairs, &colocationPair{c.Id, rsc, withRsc, rscRole, withRscRole, score, influence})
+		}
+		if len(c.ResourceSets) == 0 {
+			pair(c.Rsc, c.WithRsc, c.RscRole, c.WithRscRole, c.Score)
+			continue
+		}
+		sequential := func(set *ResourceSet) bool {
+			return set.Sequential == "" || IsTrue(set.Sequential)
+		}
+		for i, set := range c.ResourceSets {
+			score := c.Score
+			if set.Score != nil {
+				score = set.Score
+			}
+			refs := set.Resources
+			for j := 1; j < len(refs); j++ {
+				if sequential(set) {
+					pair(refs[j].Id, refs[j-1].Id, set.Role, set.Role, score)
+				} else if score != nil && *score < 0 {
+					for k := 0; k < j; k++ {
+						pair(refs[k].Id, refs[j].Id, set.Role, set.Role, score)
+					}
+				}
+			}
+			if i == len(c.ResourceSets)-1 {
+				continue
+			}
+			next := c.ResourceSets[i+1]
+			dependents, primaries := refs, next.Resources
+			if sequential(set) && len(dependents) > 0 {
+				dependents = dependents[:1]
+			}
+			if sequential(next) && len(primaries) > 0 {
+				primaries = primaries[len(primaries)-1:]
+			}
+			for _, d := range dependents {
+				for _, p := range primaries {
+					pair(d.Id, p.Id, set.Role, next.Role, c.Score)
+				}
+			}
+		}
+	}
+	return pairs
+}
+
+func matchesPattern(pattern string, ids map[string]bool) bool {
+	if pattern == "" {
+		return false
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return false
+	}
+	for id := range ids {
+		if re.MatchString(id) {
+			return true
+		}
+	}
+	return false
+}
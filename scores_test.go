@@ -0,0 +1,147 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func loadScores(t *testing.T, file string) *AllocationScores {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scores, err := parseAllocationScores(string(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return scores
+}
+
+func TestParseAllocationScores(t *testing.T) {
+	scores := loadScores(t, "testdata/scores.xml")
+	if len(scores.Allocations) != 14 || len(scores.Promotions) != 2 {
+		t.Fatalf("Expected 14 allocation and 2 promotion scores, got %d and %d", len(scores.Allocations), len(scores.Promotions))
+	}
+	s := scores.Allocations[2]
+	if s.Resource != "gctvanas-fs1o" || s.Instance != "gctvanas-fs1o:0" || s.Node != "node1" || s.Score != 10001 || s.Function != "pcmk__clone_allocate" {
+		t.Errorf("Unexpected score: %+v", s)
+	}
+	if p := scores.Promotions[1]; p.Resource != "gctvanas-fs1o" || p.Node != "node2" || p.Score != 10000 {
+		t.Errorf("Unexpected promotion score: %+v", p)
+	}
+
+	if final := scores.Final("gctvanas-lvm"); !reflect.DeepEqual(final, map[string]Score{"node1": ScoreMinusInfinity, "node2": ScoreMinusInfinity}) {
+		t.Errorf("Unexpected final scores for gctvanas-lvm: %v", final)
+	}
+	if final := scores.Final("gctvanas-fs1o:1"); !reflect.DeepEqual(final, map[string]Score{"node1": ScoreMinusInfinity, "node2": 10001}) {
+		t.Errorf("Unexpected final scores for gctvanas-fs1o:1: %v", final)
+	}
+	if final := scores.Final("gctvanas-fs1o"); !reflect.DeepEqual(final, map[string]Score{"node1": 10001, "node2": 10001}) {
+		t.Errorf("Unexpected final scores for gctvanas-fs1o: %v", final)
+	}
+
+	if _, err := parseAllocationScores("<pacemaker-result"); err == nil {
+		t.Error("Expected error for malformed output")
+	}
+}
+
+func TestExplainPlacement(t *testing.T) {
+	conf := loadConfiguration(t, "testdata/exit-reason.xml")
+	status := loadStatus(t, "testdata/exit-reason.xml")
+	scores := loadScores(t, "testdata/scores.xml")
+
+	vip := ExplainPlacement(conf, status, scores, "gctvanas-vip")
+	if !reflect.DeepEqual(vip.Preferred(), []string{"node1"}) {
+		t.Errorf("Expected gctvanas-vip to prefer node1, got %v", vip.Preferred())
+	}
+	expected := []*ScoreContribution{
+		{StickinessContribution, "rsc_defaults-options", "node1", 100},
+	}
+	if !reflect.DeepEqual(vip.Contributions, expected) {
+		t.Errorf("Unexpected contributions for gctvanas-vip: %+v", vip.Contributions)
+	}
+
+	lvm := ExplainPlacement(conf, status, scores, "gctvanas-lvm")
+	if len(lvm.Preferred()) != 0 {
+		t.Errorf("Expected gctvanas-lvm to be banned everywhere, got %v", lvm.Preferred())
+	}
+	expected = []*ScoreContribution{
+		{FailureContribution, "gctvanas-lvm", "node1", ScoreMinusInfinity},
+		{FailureContribution, "gctvanas-lvm", "node2", ScoreMinusInfinity},
+	}
+	if !reflect.DeepEqual(lvm.Contributions, expected) {
+		t.Errorf("Unexpected contributions for gctvanas-lvm: %+v", lvm.Contributions)
+	}
+
+	drbd := ExplainPlacement(conf, status, scores, "gctvanas-fs1o")
+	if len(drbd.Promotions) != 2 {
+		t.Errorf("Expected 2 promotion scores, got %d", len(drbd.Promotions))
+	}
+	expected = []*ScoreContribution{
+		{StickinessContribution, "rsc_defaults-options", "node1", 100},
+		{StickinessContribution, "rsc_defaults-options", "node2", 100},
+		{PromotionContribution, "master-gctvanas-fs1o", "node1", 10000},
+		{PromotionContribution, "master-gctvanas-fs1o", "node2", 10000},
+	}
+	if !reflect.DeepEqual(drbd.Contributions, expected) {
+		t.Errorf("Unexpected contributions for gctvanas-fs1o: %+v", drbd.Contributions)
+	}
+}
+
+func TestExplainPlacementConstraints(t *testing.T) {
+	conf := loadConfiguration(t, "testdata/simple.xml")
+	status := loadStatus(t, "testdata/simple.xml")
+	scores := &AllocationScores{Allocations: []*AllocationScore{
+		{Resource: "myAddr", Instance: "myAddr", Node: "c001n01", Score: ScoreInfinity},
+		{Resource: "myAddr", Instance: "myAddr", Node: "c001n02", Score: 0},
+	}}
+	p := ExplainPlacement(conf, status, scores, "myAddr")
+	expected := []*ScoreContribution{
+		{LocationContribution, "myAddr-prefer", "c001n01", ScoreInfinity},
+	}
+	if !reflect.DeepEqual(p.Contributions, expected) {
+		t.Errorf("Unexpected contributions: %+v", p.Contributions)
+	}
+	if !reflect.DeepEqual(p.Preferred(), []string{"c001n01"}) {
+		t.Errorf("Expected myAddr to prefer c001n01, got %v", p.Preferred())
+	}
+}
+
+func TestExplainPlacementRulesAndColocations(t *testing.T) {
+	conf, err := decodeConfiguration([]byte(`<configuration><crm_config/><nodes>` +
+		`<node id="1" uname="n1"><instance_attributes id="n1-attrs"><nvpair id="n1-pref" name="pref" value="10"/></instance_attributes></node>` +
+		`<node id="2" uname="n2"/></nodes><resources>` +
+		`<primitive id="a" class="ocf" provider="heartbeat" type="Dummy"/>` +
+		`<primitive id="b" class="ocf" provider="heartbeat" type="Dummy"/>` +
+		`<primitive id="c" class="ocf" provider="heartbeat" type="Dummy"/></resources><constraints>` +
+		`<rsc_location id="loc-a" rsc="a"><rule id="loc-a-rule" score-attribute="pref"><expression id="loc-a-expr" attribute="#uname" operation="defined"/></rule></rsc_location>` +
+		`<rsc_location id="loc-a-n2" rsc="a"><rule id="loc-a-n2-rule" score="25"><expression id="loc-a-n2-expr" attribute="#uname" operation="eq" value="n2"/></rule></rsc_location>` +
+		`<rsc_colocation id="col-ab" rsc="a" with-rsc="b" score="100"/>` +
+		`<rsc_colocation id="col-cb" rsc="c" with-rsc="b" score="200" influence="false"/>` +
+		`<rsc_colocation id="col-set" score="50"><resource_set id="col-set-0"><resource_ref id="c"/></resource_set>` +
+		`<resource_set id="col-set-1"><resource_ref id="a"/></resource_set></rsc_colocation>` +
+		`</constraints></configuration>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := ExplainPlacement(conf, &Status{}, &AllocationScores{}, "a")
+	expected := []*ScoreContribution{
+		{LocationContribution, "loc-a-rule", "n1", 10},
+		{LocationContribution, "loc-a-n2-rule", "n2", 25},
+		{ColocationContribution, "col-ab", "", 100},
+		{ColocationContribution, "col-set", "", 50},
+	}
+	if !reflect.DeepEqual(p.Contributions, expected) {
+		t.Errorf("Unexpected contributions for a: %+v", p.Contributions)
+	}
+	p = ExplainPlacement(conf, &Status{}, &AllocationScores{}, "b")
+	expected = []*ScoreContribution{
+		{ColocationContribution, "col-ab", "", 100},
+	}
+	if !reflect.DeepEqual(p.Contributions, expected) {
+		t.Errorf("Unexpected contributions for b: %+v", p.Contributions)
+	}
+}
@@ -0,0 +1,338 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/ClusterLabs/go-pacemaker/cibxml"
+)
+
+// A shadow CIB: a copy of the CIB kept in a
+// file, where changes can be staged and
+// reviewed before committing them to the
+// cluster in one step. Shadow CIBs are shared
+// with crm_shadow, and are stored in the
+// directory given by CIB_shadow_dir if set.
+type Shadow struct {
+	name string
+}
+
+// Creates a new shadow CIB, either as a copy
+// of the live CIB or empty. Fails with an error
+// matching ErrExists if a shadow with the same
+// name exists.
+func CreateShadow(name string, fromLive bool) (*Shadow, error) {
+	shadow := &Shadow{name: name}
+	var data string
+	var err error
+	if fromLive {
+		data, err = queryLive()
+		if err == nil {
+			data, err = withShadowBase(data)
+		}
+	} else {
+		data, err = emptyCib()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := shadow.write(data, os.O_EXCL); err != nil {
+		return nil, err
+	}
+	return shadow, nil
+}
+
+// Returns the existing shadow CIB with the
+// given name. Shadows created by crm_shadow do
+// not record the version of the live CIB they
+// were copied from, so Commit cannot check for
+// changes made to the live CIB since then,
+// unless the shadow is Reset first.
+func FindShadow(name string) (*Shadow, error) {
+	shadow := &Shadow{name: name}
+	if _, err := os.Stat(shadow.File()); err != nil {
+		return nil, &CibError{Code: -int(syscall.ENXIO), Name: "ENXIO", Operation: "find shadow", msg: "No shadow named " + name}
+	}
+	return shadow, nil
+}
+
+// Returns all shadow CIBs, sorted by name. See
+// FindShadow.
+func ListShadows() ([]*Shadow, error) {
+	// The directory is chosen by pacemaker, as
+	// for the file of a shadow.
+	dir := filepath.Dir(GetShadowFile(""))
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, &CibError{Operation: "list shadows", msg: err.Error()}
+	}
+	var result []*Shadow
+	for _, f := range files {
+		if f.Mode().IsRegular() && strings.HasPrefix(f.Name(), "shadow.") {
+			result = append(result, &Shadow{name: strings.TrimPrefix(f.Name(), "shadow.")})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].name < result[j].name })
+	return result, nil
+}
+
+func (shadow *Shadow) Name() string {
+	return shadow.name
+}
+
+// Returns the path of the file holding the
+// shadow CIB.
+func (shadow *Shadow) File() string {
+	return GetShadowFile(shadow.name)
+}
+
+// Opens a connection to the shadow CIB, to
+// make changes to it.
+func (shadow *Shadow) Open(options ...func(*CibOpenConfig)) (*Cib, error) {
+	return OpenCib(append(options, FromShadow(shadow.name))...)
+}
+
+// Replaces the configuration of the live CIB
+// with the configuration of the shadow, as
+// crm_shadow --commit does. The status section
+// of the shadow is not committed.
+//
+// If the configuration of the live CIB changed
+// since the shadow was created or reset, those
+// changes would be lost, so the commit fails
+// with an error matching ErrVersionConflict.
+// The check is best effort: a change made to
+// the live CIB after the check and before the
+// configuration is replaced is still lost.
+func (shadow *Shadow) Commit() error {
+	data, err := shadow.read()
+	if err != nil {
+		return err
+	}
+	root, err := cibxml.ParseString(data)
+	if err != nil {
+		return &CibError{Operation: "commit shadow", msg: err.Error()}
+	}
+	conf := root.Child("configuration")
+	if conf == nil {
+		return &CibError{Operation: "commit shadow", msg: "No configuration section in shadow " + shadow.name}
+	}
+	cib, err := OpenCib(withoutShadow, ForCommand)
+	if err != nil {
+		return err
+	}
+	defer cib.Close()
+	if base := shadowBase(data); base != nil {
+		live, err := cib.Version()
+		if err != nil {
+			return err
+		}
+		if live.AdminEpoch != base.AdminEpoch || live.Epoch != base.Epoch {
+			return &CibError{Code: -pcmkErrCibModified, Name: "pcmk_err_cib_modified", Operation: "commit shadow",
+				msg: "Live CIB changed since shadow " + shadow.name + " was created (" + base.String() + " -> " + live.String() + ")"}
+		}
+	}
+	return cib.Replace("configuration", conf.String())
+}
+
+// Returns the changes made to the configuration
+// in the shadow compared to the live CIB, or nil
+// if there are none. The status section is not
+// compared. Source and Target are the versions
+// of the live CIB and the shadow.
+func (shadow *Shadow) Diff() (*CibDiff, error) {
+	data, err := shadow.read()
+	if err != nil {
+		return nil, err
+	}
+	live, err := queryLive()
+	if err != nil {
+		return nil, err
+	}
+	source, sourceVersion, err := configurationOnly(live)
+	if err != nil {
+		return nil, err
+	}
+	target, targetVersion, err := configurationOnly(data)
+	if err != nil {
+		return nil, err
+	}
+	diff, err := createPatchset(source, target)
+	if diff != nil {
+		diff.Source = *sourceVersion
+		diff.Target = *targetVersion
+	}
+	return diff, err
+}
+
+// Discards all changes in the shadow by
+// replacing it with a copy of the live CIB.
+func (shadow *Shadow) Reset() error {
+	if _, err := shadow.read(); err != nil {
+		return err
+	}
+	data, err := queryLive()
+	if err != nil {
+		return err
+	}
+	if data, err = withShadowBase(data); err != nil {
+		return err
+	}
+	return shadow.write(data, os.O_TRUNC)
+}
+
+// Removes the shadow CIB.
+func (shadow *Shadow) Delete() error {
+	err := os.Remove(shadow.File())
+	if os.IsNotExist(err) {
+		return &CibError{Code: -int(syscall.ENXIO), Name: "ENXIO", Operation: "delete shadow", msg: "No shadow named " + shadow.name}
+	} else if err != nil {
+		return &CibError{Operation: "delete shadow", msg: err.Error()}
+	}
+	return nil
+}
+
+func (shadow *Shadow) read() (string, error) {
+	data, err := ioutil.ReadFile(shadow.File())
+	if os.IsNotExist(err) {
+		return "", &CibError{Code: -int(syscall.ENXIO), Name: "ENXIO", Operation: "read shadow", msg: "No shadow named " + shadow.name}
+	} else if err != nil {
+		return "", &CibError{Operation: "read shadow", msg: err.Error()}
+	}
+	return string(data), nil
+}
+
+// Writes the shadow, opening the file with the
+// given flag in addition to O_CREATE.
+func (shadow *Shadow) write(data string, flag int) error {
+	file := shadow.File()
+	if err := os.MkdirAll(filepath.Dir(file), 0750); err != nil {
+		return &CibError{Operation: "write shadow", msg: err.Error()}
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|flag, 0640)
+	if os.IsExist(err) {
+		return &CibError{Code: -int(syscall.EEXIST), Name: "EEXIST", Operation: "create shadow", msg: "Shadow " + shadow.name + " already exists"}
+	} else if err != nil {
+		return &CibError{Operation: "write shadow", msg: err.Error()}
+	}
+	_, err = f.WriteString(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return &CibError{Operation: "write shadow", msg: err.Error()}
+	}
+	return nil
+}
+
+// Returns the live CIB as XML, even if a
+// shadow CIB is active.
+func queryLive() (string, error) {
+	cib, err := OpenCib(withoutShadow, ForQuery)
+	if err != nil {
+		return "", err
+	}
+	defer cib.Close()
+	doc, err := cib.Query()
+	if err != nil {
+		return "", err
+	}
+	defer doc.Close()
+	return doc.ToString(), nil
+}
+
+// Returns a CIB holding only the configuration
+// section of the given one, without the version
+// attributes, and the version of the given CIB.
+func configurationOnly(data string) (string, *CibVersion, error) {
+	root, err := cibxml.ParseString(data)
+	if err != nil {
+		return "", nil, &CibError{Operation: "diff shadow", msg: err.Error()}
+	}
+	version, err := cibVersion(data)
+	if err != nil {
+		return "", nil, err
+	}
+	result := &cibxml.Element{Name: root.Name}
+	if conf := root.Child("configuration"); conf != nil {
+		result.Children = []*cibxml.Element{conf}
+	}
+	return result.String(), version, nil
+}
+
+// Returns the version of a CIB in XML format.
+func cibVersion(data string) (*CibVersion, error) {
+	var v xmlVersion
+	if err := xml.Unmarshal([]byte(data), &v); err != nil {
+		return nil, &CibError{msg: "Failed to parse CIB version: " + err.Error()}
+	}
+	version := CibVersion(v)
+	return &version, nil
+}
+
+// The version of the live CIB a shadow was
+// copied from is recorded in a comment in the
+// cib element of the shadow, which pacemaker
+// keeps when the shadow is changed.
+const shadowBasePrefix = "go-pacemaker base "
+
+// Returns the CIB with its version recorded as
+// the base of a shadow.
+func withShadowBase(data string) (string, error) {
+	version, err := cibVersion(data)
+	if err != nil {
+		return "", err
+	}
+	d := xml.NewDecoder(strings.NewReader(data))
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return "", &CibError{msg: "Failed to parse CIB: " + err.Error()}
+		}
+		if _, ok := tok.(xml.StartElement); ok {
+			break
+		}
+	}
+	offset := d.InputOffset()
+	comment := fmt.Sprintf("<!--%sadmin_epoch=%d epoch=%d-->", shadowBasePrefix, version.AdminEpoch, version.Epoch)
+	return data[:offset] + comment + data[offset:], nil
+}
+
+// Returns the version recorded by
+// withShadowBase, or nil if there is none.
+func shadowBase(data string) *CibVersion {
+	d := xml.NewDecoder(strings.NewReader(data))
+	depth := 0
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+		case xml.Comment:
+			text := strings.TrimSpace(string(t))
+			if depth != 1 || !strings.HasPrefix(text, shadowBasePrefix) {
+				continue
+			}
+			var v CibVersion
+			_, err := fmt.Sscanf(text[len(shadowBasePrefix):], "admin_epoch=%d epoch=%d", &v.AdminEpoch, &v.Epoch)
+			if err == nil {
+				return &v
+			}
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+/*
+#include <stdlib.h>
+
+extern int go_pcmk_simulate(const char *input_file, const char *graph_file, int show_scores, char **scores);
+*/
+import "C"
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// The scheduler keeps global state, so only
+// one simulation can run at a time.
+var simulateLock sync.Mutex
+
+// Simulate runs the scheduler in-process on the
+// CIB in the document, as crm_simulate does, and
+// returns the transition graph the cluster would
+// execute to reach the state it computes. The
+// document must be a complete CIB, including the
+// status section, such as a query of the live
+// CIB, a file or a shadow CIB with staged
+// changes. The cluster itself is not affected.
+func Simulate(doc *CibDocument) (*TransitionGraph, error) {
+	graph, _, err := simulate(doc, true, false)
+	if err != nil {
+		return nil, err
+	}
+	return parseTransitionGraph(graph)
+}
+
+// Scores runs the scheduler on the CIB in the
+// document like Simulate, and returns the
+// allocation and promotion scores it computed,
+// as crm_simulate -s shows them.
+func Scores(doc *CibDocument) (*AllocationScores, error) {
+	_, scores, err := simulate(doc, false, true)
+	if err != nil {
+		return nil, err
+	}
+	return parseAllocationScores(scores)
+}
+
+// ExplainPlacement computes the scores for the
+// current CIB and explains the placement of the
+// resource, see the ExplainPlacement function.
+func (cib *Cib) ExplainPlacement(resource string) (*Placement, error) {
+	doc, err := cib.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer doc.Close()
+	data := []byte(doc.ToString())
+	conf, err := decodeConfiguration(data)
+	if err != nil {
+		return nil, err
+	}
+	if conf.FindResource(resource) == nil {
+		return nil, &CibError{Code: -int(syscall.ENXIO), Name: "ENXIO", Operation: "explain placement", msg: "No resource named " + resource}
+	}
+	status, err := decodeStatus(data)
+	if err != nil {
+		return nil, err
+	}
+	scores, err := Scores(doc)
+	if err != nil {
+		return nil, err
+	}
+	return ExplainPlacement(conf, status, scores, resource), nil
+}
+
+func simulate(doc *CibDocument, withGraph, withScores bool) ([]byte, string, error) {
+	data := doc.ToString()
+	if data == "" {
+		return nil, "", &CibError{Operation: "simulate", msg: "Document is closed"}
+	}
+	dir, err := ioutil.TempDir("", "go-pacemaker")
+	if err != nil {
+		return nil, "", &CibError{Operation: "simulate", msg: err.Error()}
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "input.xml")
+	if err := ioutil.WriteFile(input, []byte(data), 0600); err != nil {
+		return nil, "", &CibError{Operation: "simulate", msg: err.Error()}
+	}
+
+	cInput := C.CString(input)
+	defer C.free(unsafe.Pointer(cInput))
+	graph := filepath.Join(dir, "graph.xml")
+	var cGraph *C.char
+	if withGraph {
+		cGraph = C.CString(graph)
+		defer C.free(unsafe.Pointer(cGraph))
+	}
+	var showScores C.int
+	if withScores {
+		showScores = 1
+	}
+	var cScores *C.char
+	simulateLock.Lock()
+	rc := C.go_pcmk_simulate(cInput, cGraph, showScores, &cScores)
+	simulateLock.Unlock()
+	var scores string
+	if cScores != nil {
+		scores = C.GoString(cScores)
+		C.free(unsafe.Pointer(cScores))
+	}
+	if rc != 0 {
+		return nil, "", formatErrorRc("simulate", int(rc))
+	}
+	if withScores && scores == "" {
+		return nil, "", &CibError{Operation: "simulate", msg: "Scheduler produced no scores"}
+	}
+
+	var result []byte
+	if withGraph {
+		result, err = ioutil.ReadFile(graph)
+		if err != nil {
+			return nil, "", &CibError{Operation: "simulate", msg: err.Error()}
+		}
+	}
+	return result, scores, nil
+}
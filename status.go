@@ -0,0 +1,417 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Typed representation of the status
+// section of the CIB, as returned by
+// CibDocument.DecodeStatus.
+type Status struct {
+	Nodes []*NodeState `xml:"node_state" json:"node_state,omitempty"`
+}
+
+// The state of a single node as recorded
+// by the controller: cluster membership,
+// transient node attributes and the
+// operation history of each resource.
+type NodeState struct {
+	Id                  string          `xml:"id,attr" json:"id"`
+	Uname               string          `xml:"uname,attr" json:"uname"`
+	InCcm               string          `xml:"in_ccm,attr,omitempty" json:"in_ccm,omitempty"`
+	Crmd                string          `xml:"crmd,attr,omitempty" json:"crmd,omitempty"`
+	Join                string          `xml:"join,attr,omitempty" json:"join,omitempty"`
+	Expected            string          `xml:"expected,attr,omitempty" json:"expected,omitempty"`
+	TransientAttributes []*AttributeSet `xml:"transient_attributes>instance_attributes" json:"transient_attributes,omitempty"`
+	Resources           []*LrmResource  `xml:"lrm>lrm_resources>lrm_resource" json:"resources,omitempty"`
+}
+
+// True if the node is a member of the
+// cluster layer. Newer versions of
+// Pacemaker record the time the node
+// joined instead of a boolean.
+func (node *NodeState) InCluster() bool {
+	return IsTrue(node.InCcm) || isTimestamp(node.InCcm)
+}
+
+// True if the controller on the node
+// is online.
+func (node *NodeState) Online() bool {
+	return node.Crmd == "online" || isTimestamp(node.Crmd)
+}
+
+// True if the node has joined the
+// controller process group.
+func (node *NodeState) Member() bool {
+	return node.Join == "member"
+}
+
+// Returns the value of a transient node
+// attribute, and whether it was found.
+func (node *NodeState) Attribute(name string) (string, bool) {
+	for _, set := range node.TransientAttributes {
+		if v, ok := set.Get(name); ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// Returns the operation history of the
+// given resource on this node, or nil.
+func (node *NodeState) Resource(id string) *LrmResource {
+	for _, rsc := range node.Resources {
+		if rsc.Id == id {
+			return rsc
+		}
+	}
+	return nil
+}
+
+func isTimestamp(s string) bool {
+	n, err := strconv.ParseInt(s, 10, 64)
+	return err == nil && n > 0
+}
+
+type LrmResource struct {
+	Id         string      `xml:"id,attr" json:"id"`
+	Class      string      `xml:"class,attr,omitempty" json:"class,omitempty"`
+	Provider   string      `xml:"provider,attr,omitempty" json:"provider,omitempty"`
+	Type       string      `xml:"type,attr,omitempty" json:"type,omitempty"`
+	Container  string      `xml:"container,attr,omitempty" json:"container,omitempty"`
+	Operations []*LrmRscOp `xml:"lrm_rsc_op" json:"lrm_rsc_op,omitempty"`
+}
+
+// A single entry in the operation history
+// of a resource. Times are in seconds since
+// the epoch, durations in milliseconds.
+type LrmRscOp struct {
+	Id              string          `xml:"id,attr" json:"id"`
+	OperationKey    string          `xml:"operation_key,attr" json:"operation_key"`
+	Operation       string          `xml:"operation,attr" json:"operation"`
+	CrmFeatureSet   string          `xml:"crm_feature_set,attr,omitempty" json:"crm_feature_set,omitempty"`
+	TransitionKey   TransitionKey   `xml:"transition-key,attr" json:"transition-key"`
+	TransitionMagic TransitionMagic `xml:"transition-magic,attr" json:"transition-magic"`
+	OnNode          string          `xml:"on_node,attr,omitempty" json:"on_node,omitempty"`
+	CallId          int             `xml:"call-id,attr" json:"call-id"`
+	RcCode          OcfExitCode     `xml:"rc-code,attr" json:"rc-code"`
+	OpStatus        OpStatus        `xml:"op-status,attr" json:"op-status"`
+	Interval        int64           `xml:"interval,attr" json:"interval"`
+	LastRun         int64           `xml:"last-run,attr,omitempty" json:"last-run,omitempty"`
+	LastRcChange    int64           `xml:"last-rc-change,attr,omitempty" json:"last-rc-change,omitempty"`
+	ExecTime        int64           `xml:"exec-time,attr,omitempty" json:"exec-time,omitempty"`
+	QueueTime       int64           `xml:"queue-time,attr,omitempty" json:"queue-time,omitempty"`
+	OpDigest        string          `xml:"op-digest,attr,omitempty" json:"op-digest,omitempty"`
+	ExitReason      string          `xml:"exit-reason,attr,omitempty" json:"exit-reason,omitempty"`
+}
+
+// Reports a transition-key or transition-magic
+// of the operation that could not be parsed.
+func (op *LrmRscOp) TransitionError() error {
+	if op.TransitionKey.Invalid != "" {
+		_, err := ParseTransitionKey(op.TransitionKey.Invalid)
+		return err
+	}
+	if op.TransitionMagic.Invalid != "" {
+		_, err := ParseTransitionMagic(op.TransitionMagic.Invalid)
+		return err
+	}
+	return nil
+}
+
+// Returns the time the operation last
+// changed its result.
+func (op *LrmRscOp) LastRcChangeTime() time.Time {
+	return time.Unix(op.LastRcChange, 0)
+}
+
+// Returns the time the operation was
+// last executed.
+func (op *LrmRscOp) LastRunTime() time.Time {
+	return time.Unix(op.LastRun, 0)
+}
+
+// Returns the interval of the operation,
+// which is recorded in milliseconds.
+func (op *LrmRscOp) IntervalDuration() time.Duration {
+	return msecDuration(op.Interval)
+}
+
+// Returns how long the operation took to run.
+func (op *LrmRscOp) ExecDuration() time.Duration {
+	return msecDuration(op.ExecTime)
+}
+
+// Returns how long the operation was queued
+// before it ran.
+func (op *LrmRscOp) QueueDuration() time.Duration {
+	return msecDuration(op.QueueTime)
+}
+
+// The transition-key identifies the
+// action in the transition graph that
+// caused an operation to be executed.
+// It is formatted as
+// "action:transition:target-rc:uuid".
+//
+// A key that cannot be parsed does not fail
+// decoding the status. Its value is kept in
+// Invalid, and the other fields are zero.
+type TransitionKey struct {
+	ActionId     int
+	TransitionId int
+	TargetRc     OcfExitCode
+	Uuid         string
+	Invalid      string
+}
+
+func ParseTransitionKey(s string) (TransitionKey, error) {
+	var key TransitionKey
+	parts := strings.SplitN(s, ":", 4)
+	if len(parts) != 4 {
+		return key, &CibError{msg: "Invalid transition key: " + s}
+	}
+	var nums [3]int
+	for i := range nums {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return key, &CibError{msg: "Invalid transition key: " + s}
+		}
+		nums[i] = n
+	}
+	key.ActionId = nums[0]
+	key.TransitionId = nums[1]
+	key.TargetRc = OcfExitCode(nums[2])
+	key.Uuid = parts[3]
+	return key, nil
+}
+
+func (key TransitionKey) String() string {
+	if key.Invalid != "" {
+		return key.Invalid
+	}
+	if key.Uuid == "" {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d:%d:%s", key.ActionId, key.TransitionId, key.TargetRc, key.Uuid)
+}
+
+func (key *TransitionKey) UnmarshalXMLAttr(attr xml.Attr) error {
+	return key.UnmarshalText([]byte(attr.Value))
+}
+
+func (key TransitionKey) MarshalText() ([]byte, error) {
+	return []byte(key.String()), nil
+}
+
+func (key *TransitionKey) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*key = TransitionKey{}
+		return nil
+	}
+	k, err := ParseTransitionKey(string(text))
+	if err != nil {
+		k = TransitionKey{Invalid: string(text)}
+	}
+	*key = k
+	return nil
+}
+
+func (key TransitionKey) MarshalXMLAttr(name xml.Name) (xml.Attr, error) {
+	s := key.String()
+	if s == "" {
+		return xml.Attr{}, nil
+	}
+	return xml.Attr{Name: name, Value: s}, nil
+}
+
+// The transition-magic records the result
+// of an operation along with its transition
+// key, formatted as "op-status:rc;key".
+//
+// As for TransitionKey, a value that cannot be
+// parsed is kept in Invalid.
+type TransitionMagic struct {
+	OpStatus OpStatus
+	RcCode   OcfExitCode
+	Key      TransitionKey
+	Invalid  string
+}
+
+func ParseTransitionMagic(s string) (TransitionMagic, error) {
+	var magic TransitionMagic
+	parts := strings.SplitN(s, ";", 2)
+	if len(parts) != 2 {
+		return magic, &CibError{msg: "Invalid transition magic: " + s}
+	}
+	var status, rc int
+	if _, err := fmt.Sscanf(parts[0], "%d:%d", &status, &rc); err != nil {
+		return magic, &CibError{msg: "Invalid transition magic: " + s}
+	}
+	key, err := ParseTransitionKey(parts[1])
+	if err != nil {
+		return magic, err
+	}
+	magic.OpStatus = OpStatus(status)
+	magic.RcCode = OcfExitCode(rc)
+	magic.Key = key
+	return magic, nil
+}
+
+func (magic TransitionMagic) String() string {
+	if magic.Invalid != "" {
+		return magic.Invalid
+	}
+	if magic.Key.Uuid == "" {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d;%s", magic.OpStatus, magic.RcCode, magic.Key)
+}
+
+func (magic *TransitionMagic) UnmarshalXMLAttr(attr xml.Attr) error {
+	return magic.UnmarshalText([]byte(attr.Value))
+}
+
+func (magic TransitionMagic) MarshalText() ([]byte, error) {
+	return []byte(magic.String()), nil
+}
+
+func (magic *TransitionMagic) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*magic = TransitionMagic{}
+		return nil
+	}
+	m, err := ParseTransitionMagic(string(text))
+	if err != nil {
+		m = TransitionMagic{Invalid: string(text)}
+	}
+	*magic = m
+	return nil
+}
+
+func (magic TransitionMagic) MarshalXMLAttr(name xml.Name) (xml.Attr, error) {
+	s := magic.String()
+	if s == "" {
+		return xml.Attr{}, nil
+	}
+	return xml.Attr{Name: name, Value: s}, nil
+}
+
+// Exit codes returned by resource agents,
+// as recorded in the rc-code attribute.
+type OcfExitCode int
+
+const (
+	OcfSuccess          OcfExitCode = 0
+	OcfError            OcfExitCode = 1
+	OcfInvalidParam     OcfExitCode = 2
+	OcfUnimplemented    OcfExitCode = 3
+	OcfInsufficientPriv OcfExitCode = 4
+	OcfNotInstalled     OcfExitCode = 5
+	OcfNotConfigured    OcfExitCode = 6
+	OcfNotRunning       OcfExitCode = 7
+	OcfRunningMaster    OcfExitCode = 8
+	OcfFailedMaster     OcfExitCode = 9
+	OcfDegraded         OcfExitCode = 190
+	OcfDegradedMaster   OcfExitCode = 191
+)
+
+var ocfExitCodeNames = map[OcfExitCode]string{
+	OcfSuccess:          "ok",
+	OcfError:            "error",
+	OcfInvalidParam:     "invalid parameter",
+	OcfUnimplemented:    "unimplemented feature",
+	OcfInsufficientPriv: "insufficient privileges",
+	OcfNotInstalled:     "not installed",
+	OcfNotConfigured:    "not configured",
+	OcfNotRunning:       "not running",
+	OcfRunningMaster:    "promoted",
+	OcfFailedMaster:     "promoted (failed)",
+	OcfDegraded:         "degraded",
+	OcfDegradedMaster:   "promoted (degraded)",
+}
+
+func (rc OcfExitCode) String() string {
+	if name, ok := ocfExitCodeNames[rc]; ok {
+		return name
+	}
+	return fmt.Sprintf("OcfExitCode(%d)", int(rc))
+}
+
+// Execution status of an operation, as
+// recorded in the op-status attribute.
+// This is independent of the exit code:
+// an operation can complete (OpDone)
+// with an error exit code.
+type OpStatus int
+
+const (
+	OpPending       OpStatus = -1
+	OpDone          OpStatus = 0
+	OpCancelled     OpStatus = 1
+	OpTimeout       OpStatus = 2
+	OpNotSupported  OpStatus = 3
+	OpError         OpStatus = 4
+	OpErrorHard     OpStatus = 5
+	OpErrorFatal    OpStatus = 6
+	OpNotInstalled  OpStatus = 7
+	OpNotConnected  OpStatus = 8
+	OpInvalid       OpStatus = 9
+	OpNoFenceDevice OpStatus = 10
+	OpNoSecrets     OpStatus = 11
+)
+
+var opStatusNames = map[OpStatus]string{
+	OpPending:       "pending",
+	OpDone:          "complete",
+	OpCancelled:     "Cancelled",
+	OpTimeout:       "Timed Out",
+	OpNotSupported:  "NOT SUPPORTED",
+	OpError:         "Error",
+	OpErrorHard:     "Hard error",
+	OpErrorFatal:    "Fatal error",
+	OpNotInstalled:  "Not installed",
+	OpNotConnected:  "Internal communication failure",
+	OpInvalid:       "Cannot execute now",
+	OpNoFenceDevice: "No fence device",
+	OpNoSecrets:     "CIB secrets unavailable",
+}
+
+func (status OpStatus) String() string {
+	if name, ok := opStatusNames[status]; ok {
+		return name
+	}
+	return fmt.Sprintf("OpStatus(%d)", int(status))
+}
+
+type statusRoot struct {
+	XMLName xml.Name
+	Status  *Status `xml:"status"`
+}
+
+func decodeStatus(data []byte) (*Status, error) {
+	var root statusRoot
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, &CibError{msg: "Failed to decode status: " + err.Error()}
+	}
+	switch root.XMLName.Local {
+	case "cib":
+		if root.Status == nil {
+			return &Status{}, nil
+		}
+		return root.Status, nil
+	case "status":
+		var status Status
+		if err := xml.Unmarshal(data, &status); err != nil {
+			return nil, &CibError{msg: "Failed to decode status: " + err.Error()}
+		}
+		return &status, nil
+	}
+	return nil, &CibError{msg: "Expected cib or status element, got " + root.XMLName.Local}
+}
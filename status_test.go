@@ -0,0 +1,113 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func loadStatus(t *testing.T, file string) *Status {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := decodeStatus(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestDecodeStatus(t *testing.T) {
+	status := loadStatus(t, "testdata/exit-reason.xml")
+
+	if len(status.Nodes) != 2 {
+		t.Fatalf("Expected 2 nodes, got %d", len(status.Nodes))
+	}
+	node := status.Nodes[0]
+	if node.Uname != "node1" || !node.InCluster() || !node.Online() || !node.Member() || node.Expected != "member" {
+		t.Errorf("Unexpected node state: %+v", node)
+	}
+	if v, ok := node.Attribute("fail-count-gctvanas-lvm"); !ok || v != "INFINITY" {
+		t.Errorf("Expected fail-count INFINITY, got %v", v)
+	}
+
+	rsc := node.Resource("gctvanas-lvm")
+	if rsc == nil || len(rsc.Operations) != 2 {
+		t.Fatalf("Unexpected history: %+v", rsc)
+	}
+	op := rsc.Operations[1]
+	if op.Operation != "start" || op.RcCode != OcfNotRunning || op.OpStatus != OpDone || op.CallId != 42 {
+		t.Errorf("Unexpected operation: %+v", op)
+	}
+	if op.ExitReason != "LVM: targetfs did not activate correctly" {
+		t.Errorf("Unexpected exit reason: %v", op.ExitReason)
+	}
+	if op.LastRcChange != 1472223442 || op.ExecTime != 577 {
+		t.Errorf("Unexpected timing: %v %v", op.LastRcChange, op.ExecTime)
+	}
+	if op.ExecDuration() != 577*time.Millisecond || op.IntervalDuration() != 0 {
+		t.Errorf("Unexpected durations: %v %v", op.ExecDuration(), op.IntervalDuration())
+	}
+	for _, monitor := range status.Nodes[0].Resource("gctvanas-vip").Operations {
+		if monitor.Id == "gctvanas-vip_monitor_30000" && monitor.IntervalDuration() != 30*time.Second {
+			t.Errorf("Expected 30s interval, got %v", monitor.IntervalDuration())
+		}
+	}
+
+	key := op.TransitionKey
+	if key.ActionId != 37 || key.TransitionId != 35 || key.TargetRc != OcfSuccess || key.Uuid != "681b3ca7-f83d-4396-a249-d6d80e0efe16" {
+		t.Errorf("Unexpected transition key: %+v", key)
+	}
+	magic := op.TransitionMagic
+	if magic.OpStatus != OpDone || magic.RcCode != OcfNotRunning || magic.Key != key {
+		t.Errorf("Unexpected transition magic: %+v", magic)
+	}
+	if magic.String() != "0:7;37:35:0:681b3ca7-f83d-4396-a249-d6d80e0efe16" {
+		t.Errorf("Unexpected transition magic string: %v", magic)
+	}
+}
+
+func TestParseTransitionKeyInvalid(t *testing.T) {
+	for _, s := range []string{"", "1:2:3", "a:2:3:uuid"} {
+		if _, err := ParseTransitionKey(s); err == nil {
+			t.Errorf("Expected error parsing %q", s)
+		}
+	}
+}
+
+func TestDecodeEmptyStatus(t *testing.T) {
+	status := loadStatus(t, "testdata/simple.xml")
+	if len(status.Nodes) != 0 {
+		t.Errorf("Expected no node state, got %v", status.Nodes)
+	}
+}
+
+func TestDecodeStatusInvalidTransition(t *testing.T) {
+	status, err := decodeStatus([]byte(`<status><node_state id="1" uname="n1"><lrm id="1"><lrm_resources>` +
+		`<lrm_resource id="a" class="ocf" provider="heartbeat" type="Dummy">` +
+		`<lrm_rsc_op id="a_last_0" operation_key="a_start_0" operation="start" transition-key="bogus" transition-magic="0:0;bogus" call-id="1" rc-code="0" op-status="0" interval="0"/>` +
+		`<lrm_rsc_op id="a_monitor_10000" operation_key="a_monitor_10000" operation="monitor" transition-key="2:1:0:uuid" transition-magic="0:0;2:1:0:uuid" call-id="2" rc-code="0" op-status="0" interval="10000"/>` +
+		`</lrm_resource></lrm_resources></lrm></node_state></status>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := status.Nodes[0].Resource("a").Operations
+	if ops[0].TransitionKey.Invalid != "bogus" || ops[0].TransitionMagic.Invalid != "0:0;bogus" || ops[0].TransitionError() == nil {
+		t.Errorf("Expected invalid transition to be reported, got %+v", ops[0])
+	}
+	if ops[1].TransitionKey.Uuid != "uuid" || ops[1].TransitionError() != nil {
+		t.Errorf("Unexpected transition for valid op: %+v", ops[1])
+	}
+	encoded, err := xml.Marshal(ops[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(encoded), `transition-key="bogus"`) {
+		t.Errorf("Expected invalid transition-key to be kept: %s", encoded)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright (C) 2017 Kristoffer Gronlund <kgronlund@suse.com>
+// See LICENSE for license.
+package pacemaker
+
+/*
+#include <stdlib.h>
+#include <crm/common/util.h>
+#include <crm/common/xml.h>
+
+extern int go_cib_validate(xmlNode *xml);
+extern int go_cib_upgrade(xmlNode *xml, int max, int *best, xmlNode **upgraded);
+*/
+import "C"
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// A violation of the schema found by Validate.
+type ValidationError struct {
+	// The path of the offending element, e.g.
+	// "/cib/configuration/resources/primitive[2]",
+	// if known.
+	Path string
+	// The line in the document as it was parsed,
+	// or 0 if not known. Documents received from
+	// the cluster have no useful line numbers.
+	Line    int
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	var loc []string
+	if e.Line > 0 {
+		loc = append(loc, fmt.Sprintf("line %d", e.Line))
+	}
+	if e.Path != "" {
+		loc = append(loc, e.Path)
+	}
+	if len(loc) == 0 {
+		return e.Message
+	}
+	return strings.Join(loc, ": ") + ": " + e.Message
+}
+
+// Errors reported by libxml2 while the lock
+// is held, collected by validationErrorCallback.
+var validateLock sync.Mutex
+var validationErrors []*ValidationError
+
+//export validationErrorCallback
+func validationErrorCallback(line C.int, path *C.char, message *C.char) {
+	validationErrors = append(validationErrors, &ValidationError{
+		Path:    C.GoString(path),
+		Line:    int(line),
+		Message: strings.TrimSpace(C.GoString(message)),
+	})
+}
+
+// Validate checks a complete CIB against the
+// schema named in its validate-with attribute,
+// as Pacemaker does before accepting an update.
+// Returns the violations found, or none if the
+// document is valid. The error is only set if
+// the document could not be validated at all,
+// e.g. because the schema is unknown.
+func (doc *CibDocument) Validate() ([]*ValidationError, error) {
+	doc.lock.Lock()
+	defer doc.lock.Unlock()
+	if doc.xml == nil {
+		return nil, &CibError{Operation: "validate", msg: "Document is closed"}
+	}
+	if _, err := doc.schemaVersion("validate"); err != nil {
+		return nil, err
+	}
+
+	validateLock.Lock()
+	validationErrors = nil
+	valid := C.go_cib_validate(doc.xml)
+	errs := validationErrors
+	validationErrors = nil
+	validateLock.Unlock()
+
+	if valid != 0 {
+		return nil, nil
+	}
+	if len(errs) == 0 {
+		return nil, formatErrorRc("validate", -pcmkErrSchemaValidation)
+	}
+	return errs, nil
+}
+
+// Upgrade returns a copy of a complete CIB
+// transformed to the target schema, e.g.
+// "pacemaker-3.0", using Pacemaker's XSLT
+// transformations as cibadmin --upgrade does.
+// An empty target upgrades to the latest schema.
+// The document must be valid against its current
+// schema. The caller must close the returned
+// document.
+func (doc *CibDocument) Upgrade(target string) (*CibDocument, error) {
+	doc.lock.Lock()
+	defer doc.lock.Unlock()
+	if doc.xml == nil {
+		return nil, &CibError{Operation: "upgrade", msg: "Document is closed"}
+	}
+	current, err := doc.schemaVersion("upgrade")
+	if err != nil {
+		return nil, err
+	}
+	max := 0
+	if target != "" {
+		s := C.CString(target)
+		defer C.free(unsafe.Pointer(s))
+		max = int(C.get_schema_version(s))
+		if max < 0 {
+			return nil, &CibError{Code: -int(syscall.EINVAL), Name: "EINVAL", Operation: "upgrade", msg: "Unknown schema " + target}
+		}
+		if current > max {
+			return nil, &CibError{Code: -int(syscall.EINVAL), Name: "EINVAL", Operation: "upgrade", msg: "Cannot downgrade to " + target}
+		}
+	}
+
+	var best C.int
+	var upgraded *C.xmlNode
+	rc := C.go_cib_upgrade(doc.xml, C.int(max), &best, &upgraded)
+	if rc != C.pcmk_ok {
+		return nil, formatErrorRc("upgrade", int(rc))
+	}
+	if max > 0 && int(best) < max {
+		C.free_xml(upgraded)
+		err := formatErrorRc("upgrade", -pcmkErrTransformFailed)
+		err.msg = "Could only upgrade to " + C.GoString(C.get_schema_name(best))
+		return nil, err
+	}
+	return newDocument(upgraded), nil
+}
+
+// Returns the index of the schema named in the
+// validate-with attribute, or -1 if there is
+// none. Must be called with the lock held.
+func (doc *CibDocument) schemaVersion(op string) (int, error) {
+	attr := C.CString("validate-with")
+	defer C.free(unsafe.Pointer(attr))
+	name := C.crm_element_value(doc.xml, attr)
+	if name == nil {
+		return -1, nil
+	}
+	version := int(C.get_schema_version(name))
+	if version < 0 {
+		return -1, &CibError{Code: -int(syscall.EINVAL), Name: "EINVAL", Operation: op, msg: "Unknown schema " + C.GoString(name)}
+	}
+	return version, nil
+}